@@ -3,8 +3,10 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"architectingsoftware.com/pub-api/schema"
 	"github.com/gin-gonic/gin"
@@ -12,6 +14,9 @@ import (
 	"github.com/nitishm/go-rejson/v4"
 )
 
+// readyzTimeout bounds how long Readyz waits for redis to answer
+const readyzTimeout = time.Second
+
 type cache struct {
 	client  *redis.Client
 	helper  *rejson.Handler
@@ -20,6 +25,7 @@ type cache struct {
 
 type PubAPI struct {
 	cache
+	stats *apiStats
 }
 
 func NewPubAPI(location string) (*PubAPI, error) {
@@ -30,6 +36,9 @@ func NewPubAPI(location string) (*PubAPI, error) {
 		Addr: location,
 	})
 
+	//Time every command we send, see redismetrics.go
+	client.AddHook(redisMetricsHook{})
+
 	//We use this context to coordinate betwen our go code and
 	//the redis operaitons
 	ctx := context.Background()
@@ -58,6 +67,7 @@ func NewPubAPI(location string) (*PubAPI, error) {
 			helper:  jsonHelper,
 			context: ctx,
 		},
+		stats: newApiStats(),
 	}, nil
 }
 
@@ -71,6 +81,7 @@ func (p *PubAPI) GetPublication(c *gin.Context) {
 
 	cacheKey := "pubs:" + pubid
 	pubBytes, err := p.helper.JSONGet(cacheKey, ".")
+	observeCacheLookup(err)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Could not find publication in cache with id=" + cacheKey})
 		return
@@ -86,15 +97,41 @@ func (p *PubAPI) GetPublication(c *gin.Context) {
 	c.JSON(http.StatusOK, pub)
 }
 
+// GetPublications returns every publication, or one page of them if
+// the client passes a limit and/or a cursor.  The cursor of the next
+// page is sent back in the body, along with the items, and in the
+// X-Next-Cursor and Link headers
 func (p *PubAPI) GetPublications(c *gin.Context) {
 
-	var pubList []schema.Publication
-	var pubItem schema.Publication
-
-	//Lets query redis for all of the items
+	//Lets find the keys of the publications, we use SCAN instead of
+	//KEYS so we do not block redis while it walks a large database
 	pattern := "pubs:*"
-	ks, _ := p.client.Keys(p.context, pattern).Result()
+	paged := wantsPage(c)
+	cursor, limit, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ks []string
+	var nextCursor string
+	if paged {
+		ks, nextCursor, err = p.scanPage(pattern, cursor, limit)
+		if errors.Is(err, errInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor " + cursor})
+			return
+		}
+	} else {
+		ks, err = p.scanKeys(pattern)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list publications in cache: " + err.Error()})
+		return
+	}
+
+	pubList := make([]schema.Publication, 0, len(ks))
 	for _, key := range ks {
+		var pubItem schema.Publication
 		err := p.getItemFromRedis(key, &pubItem)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find publication in cache with id=" + key})
@@ -103,9 +140,49 @@ func (p *PubAPI) GetPublications(c *gin.Context) {
 		pubList = append(pubList, pubItem)
 	}
 
+	if paged {
+		writePage(c, pubList, nextCursor, limit)
+		return
+	}
 	c.JSON(http.StatusOK, pubList)
 }
 
+// CountRequests returns a gin middleware that keeps the request and
+// error counters reported by /health, register it with r.Use before
+// adding the routes
+func (p *PubAPI) CountRequests() gin.HandlerFunc {
+	return p.stats.countRequests()
+}
+
+// HealthCheck is the implementation of GET /health, it reports how long
+// the API has been up, how many requests it served and how many of
+// them failed
+func (p *PubAPI) HealthCheck(c *gin.Context) {
+	health := p.stats.report()
+	health["status"] = "ok"
+	c.JSON(http.StatusOK, health)
+}
+
+// Healthz is the liveness check, it answers as long as the API can
+// serve requests at all.  It does not look at redis on purpose,
+// restarting the API will not bring redis back
+func (p *PubAPI) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz is the readiness check, it answers 503 while redis does not
+// answer a PING, so Kubernetes stops sending us traffic until it does
+func (p *PubAPI) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(p.context, readyzTimeout)
+	defer cancel()
+
+	if err := p.client.Ping(ctx).Err(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "Could not reach the cache: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
 // Helper to return a ToDoItem from redis provided a key
 func (p *PubAPI) getItemFromRedis(key string, pub *schema.Publication) error {
 
@@ -113,6 +190,7 @@ func (p *PubAPI) getItemFromRedis(key string, pub *schema.Publication) error {
 	//json structure, the second parameter "." means return the entire
 	//json structure
 	itemObject, err := p.helper.JSONGet(key, ".")
+	observeCacheLookup(err)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// These bound the number of items a client gets back from one page of
+// a list endpoint
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// scanCount is the COUNT hint we pass to SCAN when we walk over every
+// key that matches a pattern
+const scanCount = 100
+
+// page is the body of one page of a list, the items on the page and
+// the cursor of the next page, which is left out on the last page
+type page struct {
+	Items      any    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// errInvalidCursor is returned by scanPage when the cursor is not one
+// that we handed out
+var errInvalidCursor = errors.New("invalid cursor")
+
+// wantsPage returns true if the client asked for one page of a list by
+// providing a limit or a cursor.  Without them the list endpoints return
+// everything, just like they always did
+func wantsPage(c *gin.Context) bool {
+	return c.Query("limit") != "" || c.Query("cursor") != ""
+}
+
+// pageParams reads the limit and cursor query parameters.  The limit is
+// defaultPageLimit if it is not provided, and at most maxPageLimit
+func pageParams(c *gin.Context) (string, int, error) {
+	limit := defaultPageLimit
+	if limitS := c.Query("limit"); limitS != "" {
+		l, err := strconv.Atoi(limitS)
+		if err != nil || l < 1 {
+			return "", 0, fmt.Errorf("limit must be a positive number, got %q", limitS)
+		}
+		limit = l
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	return c.Query("cursor"), limit, nil
+}
+
+// setPageHeaders tells the client how to get the next page.  The cursor
+// goes in the X-Next-Cursor header, and the whole URL of the next page
+// goes in a Link header with rel="next" (RFC 8288), which a lot of HTTP
+// clients know how to follow.  Nothing is set on the last page
+func setPageHeaders(c *gin.Context, nextCursor string, limit int) {
+	if nextCursor == "" {
+		return
+	}
+
+	query := c.Request.URL.Query()
+	query.Set("cursor", nextCursor)
+	query.Set("limit", strconv.Itoa(limit))
+	next := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+
+	c.Header("X-Next-Cursor", nextCursor)
+	c.Header("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+}
+
+// writePage sends one page of a list.  The cursor of the next page is
+// in the body, for clients that do not look at headers, and in the
+// headers set by setPageHeaders
+func writePage(c *gin.Context, items any, nextCursor string, limit int) {
+	setPageHeaders(c, nextCursor, limit)
+	c.JSON(http.StatusOK, page{Items: items, NextCursor: nextCursor})
+}
+
+// scanKeys returns every key that matches the pattern.  Unlike KEYS,
+// SCAN walks the keys a batch at a time, so redis is not blocked while
+// it looks at every key in a large database.  SCAN may return a key
+// more than once, so we drop the duplicates
+func (c *cache) scanKeys(pattern string) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+
+	var cursor uint64
+	for {
+		batch, next, err := c.client.Scan(c.context, cursor, pattern, scanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		//SCAN is done when it hands back a cursor of 0
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// scanPage returns at least limit keys that match the pattern, unless
+// it runs out of keys, starting at the SCAN cursor in cursor, along with
+// the cursor of the next page.  An empty cursor starts at the beginning
+// and an empty next cursor means there are no more keys.
+//
+// limit is not an upper bound.  We keep asking SCAN for limit keys until
+// we have at least limit of them, so when the last batch comes back
+// nearly full a page has close to 2*limit keys.  SCAN treats the count
+// as a hint, so a batch can even have more keys than we asked for.  We
+// cannot cut the page short either, a SCAN cursor cannot point into the
+// middle of a batch, so the keys we cut off would never be returned
+func (c *cache) scanPage(pattern string, cursor string, limit int) ([]string, string, error) {
+	var scanCursor uint64
+	if cursor != "" {
+		sc, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, "", errInvalidCursor
+		}
+		scanCursor = sc
+	}
+
+	var keys []string
+	seen := make(map[string]bool)
+	for {
+		batch, next, err := c.client.Scan(c.context, scanCursor, pattern, int64(limit)).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		//Keep going until we have a page worth of keys, or we are at
+		//the end, a batch can even come back empty
+		scanCursor = next
+		if scanCursor == 0 || len(keys) >= limit {
+			break
+		}
+	}
+
+	nextCursor := ""
+	if scanCursor != 0 {
+		nextCursor = strconv.FormatUint(scanCursor, 10)
+	}
+	return keys, nextCursor, nil
+}
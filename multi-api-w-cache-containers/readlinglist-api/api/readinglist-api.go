@@ -3,8 +3,10 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"architectingsoftware.com/reading-list-api/schema"
 	"github.com/gin-gonic/gin"
@@ -14,6 +16,9 @@ import (
 	"github.com/go-resty/resty/v2"
 )
 
+// readyzTimeout bounds how long Readyz waits for redis to answer
+const readyzTimeout = time.Second
+
 type cache struct {
 	client  *redis.Client
 	helper  *rejson.Handler
@@ -24,6 +29,7 @@ type ReadingListAPI struct {
 	cache
 	pubAPIURL string
 	apiClient *resty.Client
+	stats     *apiStats
 }
 
 func NewReadingListAPI(location string, pubAPIurl string) (*ReadingListAPI, error) {
@@ -35,6 +41,9 @@ func NewReadingListAPI(location string, pubAPIurl string) (*ReadingListAPI, erro
 		Addr: location,
 	})
 
+	//Time every command we send, see redismetrics.go
+	client.AddHook(redisMetricsHook{})
+
 	//We use this context to coordinate betwen our go code and
 	//the redis operaitons
 	ctx := context.Background()
@@ -65,6 +74,7 @@ func NewReadingListAPI(location string, pubAPIurl string) (*ReadingListAPI, erro
 		},
 		pubAPIURL: pubAPIurl,
 		apiClient: apiClient,
+		stats:     newApiStats(),
 	}, nil
 }
 
@@ -78,6 +88,7 @@ func (r *ReadingListAPI) GetReadingList(c *gin.Context) {
 
 	cacheKey := "publist:" + rlId
 	rlBytes, err := r.helper.JSONGet(cacheKey, ".")
+	observeCacheLookup(err)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Could not find reading list in cache with id=" + cacheKey})
 		return
@@ -177,15 +188,41 @@ func (r *ReadingListAPI) RedirectWithPublication(c *gin.Context) {
 	c.Redirect(http.StatusMovedPermanently, pub.Link)
 }
 
+// GetReadingLists returns every reading list, or one page of them if
+// the client passes a limit and/or a cursor.  The cursor of the next
+// page is sent back in the body, along with the items, and in the
+// X-Next-Cursor and Link headers
 func (r *ReadingListAPI) GetReadingLists(c *gin.Context) {
 
-	var readList []schema.ReadingList
-	var readItem schema.ReadingList
-
-	//Lets query redis for all of the items
+	//Lets find the keys of the reading lists, we use SCAN instead of
+	//KEYS so we do not block redis while it walks a large database
 	pattern := "publist:*"
-	ks, _ := r.client.Keys(r.context, pattern).Result()
+	paged := wantsPage(c)
+	cursor, limit, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var ks []string
+	var nextCursor string
+	if paged {
+		ks, nextCursor, err = r.scanPage(pattern, cursor, limit)
+		if errors.Is(err, errInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor " + cursor})
+			return
+		}
+	} else {
+		ks, err = r.scanKeys(pattern)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list reading lists in cache: " + err.Error()})
+		return
+	}
+
+	readList := make([]schema.ReadingList, 0, len(ks))
 	for _, key := range ks {
+		var readItem schema.ReadingList
 		err := r.getItemFromRedis(key, &readItem)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find reading list in cache with id=" + key})
@@ -194,9 +231,53 @@ func (r *ReadingListAPI) GetReadingLists(c *gin.Context) {
 		readList = append(readList, readItem)
 	}
 
+	if paged {
+		writePage(c, readList, nextCursor, limit)
+		return
+	}
 	c.JSON(http.StatusOK, readList)
 }
 
+// CountRequests returns a gin middleware that keeps the request and
+// error counters reported by /health, register it with r.Use before
+// adding the routes
+func (r *ReadingListAPI) CountRequests() gin.HandlerFunc {
+	return r.stats.countRequests()
+}
+
+// HealthCheck is the implementation of GET /health, it reports how long
+// the API has been up, how many requests it served and how many of
+// them failed
+func (r *ReadingListAPI) HealthCheck(c *gin.Context) {
+	health := r.stats.report()
+	health["status"] = "ok"
+	c.JSON(http.StatusOK, health)
+}
+
+// Healthz is the liveness check, it answers as long as the API can
+// serve requests at all.  It does not look at redis on purpose,
+// restarting the API will not bring redis back
+func (r *ReadingListAPI) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz is the readiness check, it answers 503 while redis does not
+// answer a PING, so Kubernetes stops sending us traffic until it does
+//
+// We only check our own cache, not the publications API.  If it is
+// down we still answer everything but the paper redirects, and taking
+// every reading list API out of service with it would not help
+func (r *ReadingListAPI) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(r.context, readyzTimeout)
+	defer cancel()
+
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "Could not reach the cache: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
 // Helper to return a ToDoItem from redis provided a key
 func (r *ReadingListAPI) getItemFromRedis(key string, rl *schema.ReadingList) error {
 
@@ -204,6 +285,7 @@ func (r *ReadingListAPI) getItemFromRedis(key string, rl *schema.ReadingList) er
 	//json structure, the second parameter "." means return the entire
 	//json structure
 	itemObject, err := r.helper.JSONGet(key, ".")
+	observeCacheLookup(err)
 	if err != nil {
 		return err
 	}
@@ -71,11 +71,25 @@ func main() {
 	r := gin.Default()
 	r.Use(cors.Default())
 
+	//Count every request, so /health can report real numbers, and
+	//count and time them by route for prometheus
+	r.Use(apiHandler.CountRequests())
+	r.Use(api.RecordMetrics())
+
 	r.GET("/publists", apiHandler.GetReadingLists)
 	r.GET("/publists/:id", apiHandler.GetReadingList)
 	r.GET("/publists/:id/:idx", apiHandler.GetPubFromReadingList)
 	r.GET("/publists/:id/:idx/paper", apiHandler.RedirectWithPublication)
 
+	//Health checks, /healthz and /readyz are what the Kubernetes
+	//liveness and readiness probes call
+	r.GET("/health", apiHandler.HealthCheck)
+	r.GET("/healthz", apiHandler.Healthz)
+	r.GET("/readyz", apiHandler.Readyz)
+
+	//Metrics for prometheus to scrape
+	r.GET("/metrics", api.MetricsHandler())
+
 	//For now we will just support gets
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
 	r.Run(serverPath)
@@ -1,3 +1,3 @@
 #!/bin/bash
 docker buildx create --use 
-docker buildx build --platform linux/amd64,linux/arm64 -f ./dockerfile.better . -t architectingsoftware/todo-api:v5 --push
\ No newline at end of file
+docker buildx build --platform linux/amd64,linux/arm64 -f ./dockerfile.better .. -t architectingsoftware/todo-api:v5 --push
\ No newline at end of file
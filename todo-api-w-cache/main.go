@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"drexel.edu/todo-lib/web"
 	"drexel.edu/todo/api"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -13,8 +14,9 @@ import (
 // Global variables to hold the command line flags to drive the todo CLI
 // application
 var (
-	hostFlag string
-	portFlag uint
+	hostFlag  string
+	portFlag  uint
+	storeFlag string
 )
 
 // processCmdLineFlags parses the command line flags for our CLI
@@ -48,6 +50,11 @@ func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.UintVar(&portFlag, "p", 1080, "Default Port")
 
+	//The storage backend can be picked at startup.  If this flag is not
+	//set we will look at the TODO_STORE environment variable, and then
+	//fall back to redis
+	flag.StringVar(&storeFlag, "s", "", "Storage backend: memory, file or redis")
+
 	flag.Parse()
 }
 
@@ -59,21 +66,38 @@ func main() {
 	r := gin.Default()
 	r.Use(cors.Default())
 
-	apiHandler, err := api.New()
+	apiHandler, err := api.NewWithStoreType(storeFlag)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	//Give every request an id, it is sent back with errors so they
+	//can be matched up with the log
+	r.Use(web.RequestId())
+
+	//Count every request, so /health can report real numbers, and
+	//count and time them by route for prometheus
+	r.Use(apiHandler.CountRequests())
+	r.Use(web.RecordMetrics())
+
 	r.GET("/todo", apiHandler.ListAllTodos)
+	r.GET("/todo/export", apiHandler.ExportToDos)
+	r.POST("/todo/bulk", apiHandler.ImportToDos)
 	r.POST("/todo", apiHandler.AddToDo)
 	r.PUT("/todo", apiHandler.UpdateToDo)
 	r.DELETE("/todo", apiHandler.DeleteAllToDo)
 	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
 	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.PATCH("/todo/:id", apiHandler.PatchToDo)
+	r.POST("/todo/:id/complete", apiHandler.CompleteToDo)
+	r.POST("/todo/:id/reopen", apiHandler.ReopenToDo)
 
 	r.GET("/crash", apiHandler.CrashSim)
 	r.GET("/health", apiHandler.HealthCheck)
+	r.GET("/healthz", apiHandler.Healthz)
+	r.GET("/readyz", apiHandler.Readyz)
+	r.GET("/metrics", web.MetricsHandler())
 
 	//We will now show a common way to version an API and add a new
 	//version of an API handler under /v2.  This new API will support
@@ -1,20 +1,37 @@
 package api
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
 
-	"drexel.edu/todo-events/db"
 	"drexel.edu/todo-events/events"
+	"drexel.edu/todo-lib/db"
+	"drexel.edu/todo-lib/web"
 	"github.com/gin-gonic/gin"
 )
 
 // The api package creates and maintains a reference to the data handler
-// this is a good design practice
+// this is a good design practice.
+//
+// Every event goes out through publisher, see Notify.  It is a
+// NopPublisher until an event listener is added, so the handlers can
+// always publish.  eventHandler is the event manager behind publisher,
+// if there is one, it is what /event/:enableFlag starts and stops, and
+// stream fans its events out to the clients of /todo/stream.
+// allowedOrigins are the origins of the pages, other than our own, that
+// can open the WebSocket, see AllowOrigins
 type ToDoAPI struct {
-	db           *db.ToDo
-	eventHandler *events.ToDoEventManager
+	db             *db.ToDo
+	publisher      events.Publisher
+	eventHandler   *events.ToDoEventManager
+	stream         *events.Stream
+	allowedOrigins []string
+	stats          *web.Stats
 }
 
 func New() (*ToDoAPI, error) {
@@ -24,30 +41,92 @@ func New() (*ToDoAPI, error) {
 		return nil, err
 	}
 
+	return NewWithDB(dbHandler), nil
+}
+
+// NewWithStoreType creates the API using the named storage backend,
+// see db.NewWithStoreType for the supported values
+func NewWithStoreType(storeType string) (*ToDoAPI, error) {
+	dbHandler, err := db.NewWithStoreType(storeType)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWithDB(dbHandler), nil
+}
+
+// NewWithDB creates the API around an existing db.ToDo, this is
+// handy when you want to control exactly which store is used
+func NewWithDB(dbHandler *db.ToDo) *ToDoAPI {
 	//By default we will not be doing eventing
 	return &ToDoAPI{
 		db:           dbHandler,
+		publisher:    events.NopPublisher{},
 		eventHandler: nil,
-	}, nil
+		stats:        web.NewStats(),
+	}
+}
+
+// AddEventListener creates an event manager with the options provided
+// that logs every event, and starts it.  Use ConnectEventListener
+// instead to bring your own event manager, with your own handlers
+// subscribed to it
+func (td *ToDoAPI) AddEventListener(options events.Options) {
+	eventManager := events.NewToDoEventManagerWithOptions(options)
+	for _, eventType := range events.EventTypes {
+		eventManager.Subscribe(eventType, logEvent)
+	}
+	td.ConnectEventListener(eventManager)
+	eventManager.Start()
 }
 
-func (td *ToDoAPI) AddEventListener() {
-	td.eventHandler = events.NewToDoEventManager()
-	td.eventHandler.Start()
+// logEvent is the event handler AddEventListener subscribes to every
+// type of event
+func logEvent(event *events.ToDoEvent) {
+	log.Printf("Processing %s event", event.EventID)
 }
 
+// ConnectEventListener publishes the events of the API to the event
+// manager provided, lets /event/:enableFlag start and stop it, and
+// streams its events on /todo/stream.  A nil event manager turns
+// eventing off
 func (td *ToDoAPI) ConnectEventListener(eventManager *events.ToDoEventManager) {
+	td.ConnectPublisher(nil)
+	if eventManager == nil {
+		return
+	}
+	td.publisher = eventManager
 	td.eventHandler = eventManager
+	td.stream = events.NewStream(eventManager, events.DefaultStreamHistory)
+}
+
+// ConnectPublisher publishes the events of the API to the publisher
+// provided.  It is not an event manager, so /event/:enableFlag cannot
+// start and stop it and there is no /todo/stream.  A nil publisher
+// turns eventing off
+func (td *ToDoAPI) ConnectPublisher(publisher events.Publisher) {
+	if publisher == nil {
+		publisher = events.NopPublisher{}
+	}
+	if td.stream != nil {
+		td.stream.Close()
+		td.stream = nil
+	}
+	td.publisher = publisher
+	td.eventHandler = nil
 }
 
+// StopEventListener stops the event manager, if there is one
 func (td *ToDoAPI) StopEventListener() {
-	td.eventHandler.Stop()
+	if td.eventHandler != nil {
+		td.eventHandler.Stop()
+	}
 }
 
+// Notify publishes an event, every handler uses it to send its events.
+// It never fails, if eventing is off or stopped the event is dropped
 func (td *ToDoAPI) Notify(event *events.ToDoEvent) {
-	if td.eventHandler == nil {
-		td.eventHandler.Notify(event)
-	}
+	td.publisher.Notify(event)
 }
 
 //Below we implement the API functions.  Some of the framework
@@ -59,16 +138,22 @@ func (td *ToDoAPI) Notify(event *events.ToDoEvent) {
 //	  for example, 200 for OK, 404 for not found, etc.  This is done
 //	  using the c.JSON() function
 //   4) How to return an error code and abort the request.  This is
-//	  done using the c.AbortWithStatus() function
+//	  done using the c.AbortWithStatusJSON() function, wrapped by
+//	  web.AbortWithError and web.AbortWithDbError, which every one
+//	  of our todo APIs shares, so every error has the same JSON body
 
 // implementation for GET /todo
-// returns all todos
+// returns all todos, or one page of them if the client
+// passes a limit and/or a cursor, see listTodosPage
 func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
+	if web.WantsPage(c) {
+		td.listTodosPage(c)
+		return
+	}
 
 	todoList, err := td.db.GetAllItems()
 	if err != nil {
-		log.Println("Error Getting All Items: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		web.AbortWithDbError(c, "Error getting all items", err)
 		return
 	}
 	//Note that the database returns a nil slice if there are no items
@@ -80,59 +165,54 @@ func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 	}
 
 	evnt := events.NewEvent(events.ToDoQueryEvent, "todoList", todoList)
-	td.eventHandler.Notify(evnt)
+	td.Notify(evnt)
 
 	c.JSON(http.StatusOK, todoList)
 }
 
-// implementation for GET /v2/todo
-// returns todos that are either done or not done
-// depending on the value of the done query parameter
-// for example, /v2/todo?done=true will return all
-// todos that are done.  Note you can have multiple
-// query parameters, for example /v2/todo?done=true&foo=bar
-func (td *ToDoAPI) ListSelectTodos(c *gin.Context) {
-	//lets first load the data
-	todoList, err := td.db.GetAllItems()
+// implementation for GET /todo?limit=<n>&cursor=<cursor>
+// returns one page of todos, as {"items": [...], "nextCursor": ...}.
+// The first page is requested with just a limit, the cursor of the
+// next page is sent back in the body, in the X-Next-Cursor header
+// and in a Link header, and there is no cursor on the last page
+func (td *ToDoAPI) listTodosPage(c *gin.Context) {
+	cursor, limit, err := web.PageParams(c)
 	if err != nil {
-		log.Println("Error Getting Database Items: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		web.AbortWithError(c, http.StatusBadRequest, web.CodeBadRequest, "Error reading paging parameters", err)
 		return
 	}
-	//If the database is empty, make an empty slice so that the
-	//JSON marshalling works correctly
-	if todoList == nil {
-		todoList = make([]db.ToDoItem, 0)
-	}
 
-	//Note that the query parameter is a string, so we
-	//need to convert it to a bool
-	doneS := c.Query("done")
-
-	//if the doneS is empty, then we will return all items
-	if doneS == "" {
-		c.JSON(http.StatusOK, todoList)
+	todoList, nextCursor, err := td.db.GetItemsPage(cursor, limit)
+	if err != nil {
+		web.AbortWithDbError(c, "Error getting a page of items", err)
 		return
 	}
 
-	//Now we can handle the case where doneS is not empty
-	//and we need to filter the list based on the doneS value
+	evnt := events.NewEvent(events.ToDoQueryEvent, "todoList", todoList)
+	td.Notify(evnt)
+
+	web.WritePage(c, todoList, nextCursor, limit)
+}
 
-	done, err := strconv.ParseBool(doneS)
+// implementation for GET /v2/todo
+// returns the todos that match the query parameters, for
+// example /v2/todo?done=false&tag=school&sort=dueDate returns
+// the open todos tagged school, the ones due first at the top.
+// See db.ParseQuery for all of the parameters, they can be
+// combined, and parameters we do not know about are ignored
+func (td *ToDoAPI) ListSelectTodos(c *gin.Context) {
+	//The db package turns the query parameters into a db.Query, so
+	//every version of this API understands exactly the same ones
+	query, err := db.ParseQuery(c.Request.URL.Query())
 	if err != nil {
-		log.Println("Error converting done to bool: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		web.AbortWithDbError(c, "Error parsing query", err)
 		return
 	}
 
-	//Now we need to filter the list based on the done value
-	//that was passed in.  We will create a new slice and
-	//only add items that match the done value
-	var filteredList []db.ToDoItem
-	for _, item := range todoList {
-		if item.IsDone == done {
-			filteredList = append(filteredList, item)
-		}
+	filteredList, err := td.db.QueryItems(query)
+	if err != nil {
+		web.AbortWithDbError(c, "Error querying items", err)
+		return
 	}
 
 	//Note that the database returns a nil slice if there are no items
@@ -147,41 +227,39 @@ func (td *ToDoAPI) ListSelectTodos(c *gin.Context) {
 }
 
 // implementation for GET /todo/:id
-// returns a single todo
+// returns a single todo, with its version in the ETag header.
+// If the client sends that ETag back in If-None-Match, and the todo
+// did not change, we answer 304 Not Modified without the todo
 func (td *ToDoAPI) GetToDo(c *gin.Context) {
 
-	//Note go is minimalistic, so we have to get the
-	//id parameter using the Param() function, and then
-	//convert it to an int64 using the strconv package
-	idS := c.Param("id")
-	id64, err := strconv.ParseInt(idS, 10, 32)
-	if err != nil {
-		log.Println("Error converting id to int64: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+	id, ok := web.IdParam(c)
+	if !ok {
 		return
 	}
 
-	//Note that ParseInt always returns an int64, so we have to
-	//convert it to an int before we can use it.
-	todoItem, err := td.db.GetItem(int(id64))
+	todoItem, err := td.db.GetItem(id)
 	if err != nil {
-		log.Println("Item not found: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
+		web.AbortWithDbError(c, "Error getting item", err)
+		return
+	}
+
+	web.SetETag(c, todoItem)
+	if web.NotModified(c, todoItem) {
+		c.Status(http.StatusNotModified)
 		return
 	}
 
 	evnt := events.NewEvent(events.ToDoQueryEvent, "todoItem", todoItem)
-	td.eventHandler.Notify(evnt)
+	td.Notify(evnt)
 	//Git will automatically convert the struct to JSON
 	//and set the content-type header to application/json
 	c.JSON(http.StatusOK, todoItem)
 }
 
 // implementation for POST /todo
-// adds a new todo
+// adds a new todo, if the body has no id (or an id of 0)
+// the next free id is assigned to it
 func (td *ToDoAPI) AddToDo(c *gin.Context) {
-	var todoItem db.ToDoItem
-
 	//With HTTP based APIs, a POST request will usually
 	//have a body that contains the data to be added
 	//to the database.  The body is usually JSON, so
@@ -190,61 +268,189 @@ func (td *ToDoAPI) AddToDo(c *gin.Context) {
 	//This framework exposes the raw body via c.Request.Body
 	//but it also provides a helper function ShouldBindJSON()
 	//that will extract the body, convert it to JSON and
-	//bind it to a struct for us.  It will also report an error
-	//if the body is not JSON or if the JSON does not match
-	//the struct we are binding to.
-	if err := c.ShouldBindJSON(&todoItem); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+	//bind it to a struct for us.  We use our own web.BindItem
+	//instead, which is stricter, a field that a todo does not
+	//have is reported back instead of being ignored.
+	todoItem, ok := web.BindItem(c)
+	if !ok {
 		return
 	}
 
-	if err := td.db.AddItem(todoItem); err != nil {
-		log.Println("Error adding item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	//If the client did not provide an id, AddItem picks the next free
+	//one, so we need to send the stored item back to the client
+	newItem, err := td.addItem(todoItem)
+	if err != nil {
+		web.AbortWithDbError(c, "Error adding item", err)
 		return
 	}
-	evnt := events.NewEvent(events.ToDoAddEvent, "todoItem", todoItem)
-	td.eventHandler.Notify(evnt)
 
-	c.JSON(http.StatusOK, todoItem)
+	//With a POST that creates something, the standard response is
+	//201 Created along with a Location header pointing at the new item
+	c.Header("Location", fmt.Sprintf("/todo/%d", newItem.Id))
+	web.SetETag(c, newItem)
+	c.JSON(http.StatusCreated, newItem)
 }
 
 // implementation for PUT /todo
-// Web api standards use PUT for Updates
+// Web api standards use PUT for Updates.  With an If-Match header
+// the todo is only replaced if it is still at that version, otherwise
+// we answer 412 Precondition Failed
 func (td *ToDoAPI) UpdateToDo(c *gin.Context) {
-	var todoItem db.ToDoItem
-	if err := c.ShouldBindJSON(&todoItem); err != nil {
-		log.Println("Error binding JSON: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+	version, ok := web.IfMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	todoItem, ok := web.BindItem(c)
+	if !ok {
+		return
+	}
+
+	//The db sets the timestamps, so send back the item as it was stored
+	updatedItem, err := td.updateItem(todoItem, version)
+	if err != nil {
+		web.AbortWithDbError(c, "Error updating item", err)
 		return
 	}
 
-	if err := td.db.UpdateItem(todoItem); err != nil {
-		log.Println("Error updating item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	web.SetETag(c, updatedItem)
+	c.JSON(http.StatusOK, updatedItem)
+}
+
+// implementation for PATCH /todo/:id
+// changes just the fields of a todo that are in the body, the body is
+// a JSON Merge Patch (RFC 7396).  For example {"done": true} marks the
+// todo done, and {"dueDate": null} removes its due date.  If-Match
+// works just like it does for PUT
+func (td *ToDoAPI) PatchToDo(c *gin.Context) {
+	id, ok := web.IdParam(c)
+	if !ok {
+		return
+	}
+	version, ok := web.IfMatchVersion(c)
+	if !ok {
 		return
 	}
 
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		web.AbortWithError(c, http.StatusBadRequest, web.CodeBadRequest, "Error reading body", err)
+		return
+	}
+
+	patchedItem, err := td.db.PatchItem(id, patch, version)
+	if err != nil {
+		web.AbortWithDbError(c, "Error patching item", err)
+		return
+	}
+
+	evnt := events.NewEvent(events.ToDoUpdateEvent, "todoItem", patchedItem)
+	td.Notify(evnt)
+
+	web.SetETag(c, patchedItem)
+	c.JSON(http.StatusOK, patchedItem)
+}
+
+// implementation for POST /todo/:id/complete
+// marks a todo done
+func (td *ToDoAPI) CompleteToDo(c *gin.Context) {
+	td.changeDoneStatus(c, true)
+}
+
+// implementation for POST /todo/:id/reopen
+// marks a todo not done
+func (td *ToDoAPI) ReopenToDo(c *gin.Context) {
+	td.changeDoneStatus(c, false)
+}
+
+// changeDoneStatus does the work for CompleteToDo and ReopenToDo.  Doing
+// it twice is fine, completing a todo that is already done keeps the
+// time it was first completed
+func (td *ToDoAPI) changeDoneStatus(c *gin.Context, done bool) {
+	id, ok := web.IdParam(c)
+	if !ok {
+		return
+	}
+
+	todoItem, err := td.db.ChangeItemDoneStatus(id, done)
+	if err != nil {
+		web.AbortWithDbError(c, "Error changing the done status of item", err)
+		return
+	}
+	web.SetETag(c, todoItem)
+
 	evnt := events.NewEvent(events.ToDoUpdateEvent, "todoItem", todoItem)
-	td.eventHandler.Notify(evnt)
+	td.Notify(evnt)
+
 	c.JSON(http.StatusOK, todoItem)
 }
 
+// implementation for POST /todo/bulk
+// imports the todos in the body, which is a JSON array of todos, CSV
+// with a header row, or NDJSON with a todo on every line.  The format
+// comes from the format query parameter or the Content-Type header.
+// A todo that cannot be imported does not stop the others, we send back
+// how many were imported, their ids, and what was wrong with the rest
+func (td *ToDoAPI) ImportToDos(c *gin.Context) {
+	format := web.ImportFormat(c)
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, web.MaxImportBytes)
+
+	//Every todo that is imported is published as an add event of its
+	//own, just like a todo added with POST /todo, so subscribers and
+	//the stream handle imports without knowing about them
+	result, err := td.db.ImportItemsFunc(body, format, func(item db.ToDoItem) {
+		td.Notify(events.NewEvent(events.ToDoAddEvent, "todoItem", item))
+	})
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		web.AbortWithError(c, http.StatusRequestEntityTooLarge, web.CodePayloadTooLarge, "Error importing items",
+			fmt.Errorf("an import can be at most %d bytes", tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		web.AbortWithDbError(c, "Error importing items", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// implementation for GET /todo/export?format=json|csv|ndjson
+// sends back every todo, in id order, as a file to download.  The
+// export can be imported again with POST /todo/bulk, into this API
+// or any other one, or with todo import in the CLI
+func (td *ToDoAPI) ExportToDos(c *gin.Context) {
+	format := web.ExportFormat(c)
+
+	//We build the whole export before we send anything, so if it
+	//fails half way the client gets an error instead of half a file
+	var export bytes.Buffer
+	if err := td.db.ExportItems(&export, format); err != nil {
+		web.AbortWithDbError(c, "Error exporting items", err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="todos.%s"`, format))
+	c.Data(http.StatusOK, web.FormatContentTypes[format], export.Bytes())
+}
+
 // implementation for DELETE /todo/:id
-// deletes a todo
+// deletes a todo, with an If-Match header only if it is still at
+// that version
 func (td *ToDoAPI) DeleteToDo(c *gin.Context) {
-	idS := c.Param("id")
-	id64, _ := strconv.ParseInt(idS, 10, 32)
-
-	if err := td.db.DeleteItem(int(id64)); err != nil {
-		log.Println("Error deleting item: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+	id, ok := web.IdParam(c)
+	if !ok {
+		return
+	}
+	version, ok := web.IfMatchVersion(c)
+	if !ok {
 		return
 	}
 
-	evnt := events.NewEvent(events.ToDoDeleteEvent, "id", id64)
-	td.eventHandler.Notify(evnt)
+	if err := td.deleteItem(id, version); err != nil {
+		web.AbortWithDbError(c, "Error deleting item", err)
+		return
+	}
 
 	c.Status(http.StatusOK)
 }
@@ -254,17 +460,47 @@ func (td *ToDoAPI) DeleteToDo(c *gin.Context) {
 func (td *ToDoAPI) DeleteAllToDo(c *gin.Context) {
 
 	if err := td.db.DeleteAll(); err != nil {
-		log.Println("Error deleting all items: ", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		web.AbortWithDbError(c, "Error deleting all items", err)
 		return
 	}
 
 	evnt := events.NewEvent(events.ToDoDeleteEvent, "id", "all")
-	td.eventHandler.Notify(evnt)
+	td.Notify(evnt)
 
 	c.Status(http.StatusOK)
 }
 
+// addItem, updateItem and deleteItem change the todos and publish the
+// event for the change.  The HTTP handlers and the commands sent over
+// the WebSocket both use them, so a change looks the same to everyone
+// listening no matter which API it came through
+
+func (td *ToDoAPI) addItem(item db.ToDoItem) (db.ToDoItem, error) {
+	newItem, err := td.db.AddItem(item)
+	if err != nil {
+		return db.ToDoItem{}, err
+	}
+	td.Notify(events.NewEvent(events.ToDoAddEvent, "todoItem", newItem))
+	return newItem, nil
+}
+
+func (td *ToDoAPI) updateItem(item db.ToDoItem, version int) (db.ToDoItem, error) {
+	updatedItem, err := td.db.UpdateItem(item, version)
+	if err != nil {
+		return db.ToDoItem{}, err
+	}
+	td.Notify(events.NewEvent(events.ToDoUpdateEvent, "todoItem", updatedItem))
+	return updatedItem, nil
+}
+
+func (td *ToDoAPI) deleteItem(id int, version int) error {
+	if err := td.db.DeleteItem(id, version); err != nil {
+		return err
+	}
+	td.Notify(events.NewEvent(events.ToDoDeleteEvent, "id", id))
+	return nil
+}
+
 /*   SPECIAL HANDLERS FOR DEMONSTRATION - CRASH SIMULATION AND HEALTH CHECK */
 
 // implementation for GET /crash
@@ -275,19 +511,46 @@ func (td *ToDoAPI) CrashSim(c *gin.Context) {
 	panic("Simulating an unexpected crash")
 }
 
+// CountRequests returns a gin middleware that keeps the request and
+// error counters reported by /health, register it with r.Use before
+// adding the routes
+func (td *ToDoAPI) CountRequests() gin.HandlerFunc {
+	return td.stats.CountRequests()
+}
+
+// implementation of GET /healthz
+// This is the liveness check, it answers as long as the API can
+// serve requests at all.  It does not look at the store on purpose,
+// if redis goes away restarting the API will not bring it back, so
+// we do not want Kubernetes to restart us, see Readyz for that
+func (td *ToDoAPI) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// implementation of GET /readyz
+// This is the readiness check, it answers 503 while the store cannot
+// be used, for example while redis is down or the database file
+// cannot be read.  Kubernetes stops sending us traffic until it
+// answers 200 again
+func (td *ToDoAPI) Readyz(c *gin.Context) {
+	if err := td.db.Ping(); err != nil {
+		log.Println("Store is not ready: ", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
 // implementation of GET /health. It is a good practice to build in a
-// health check for your API.  Below the results are just hard coded
-// but in a real API you can provide detailed information about the
-// health of your API with a Health Check
+// health check for your API.  This one reports how long the API has
+// been up, how many requests it served and how many of them failed.
+// It answers 200 as long as we are running, /readyz is the one that
+// checks the store
 func (td *ToDoAPI) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK,
-		gin.H{
-			"status":             "ok",
-			"version":            "1.0.0",
-			"uptime":             100,
-			"users_processed":    1000,
-			"errors_encountered": 10,
-		})
+	health := td.stats.Report()
+	health["status"] = "ok"
+	health["version"] = "1.0.0"
+	c.JSON(http.StatusOK, health)
 }
 
 // implementation for GET /events/:enableFlag
@@ -300,8 +563,14 @@ func (td *ToDoAPI) EventEnabler(c *gin.Context) {
 	enable := c.Param("enableFlag")
 	eFlag, err := strconv.ParseBool(enable)
 	if err != nil {
-		log.Println("Error converting enable flag, must be bool: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		web.AbortWithError(c, http.StatusBadRequest, web.CodeBadRequest, "Error converting enable flag, must be bool", err)
+		return
+	}
+
+	//Without an event manager there is nothing to start or stop
+	if td.eventHandler == nil {
+		web.AbortWithError(c, http.StatusConflict, web.CodeConflict, "Error changing eventing",
+			errors.New("eventing is not set up, there is no event manager"))
 		return
 	}
 
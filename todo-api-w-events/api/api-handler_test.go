@@ -0,0 +1,232 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"drexel.edu/todo-events/events"
+	"drexel.edu/todo-lib/db"
+	"github.com/gin-gonic/gin"
+)
+
+// These tests send requests to the handlers through a gin router, the
+// same way main.go sets it up, and check which events the handlers
+// publish
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// recordingPublisher is a Publisher that remembers the type of every
+// event it gets
+type recordingPublisher struct {
+	mu    sync.Mutex
+	types []string
+}
+
+func (p *recordingPublisher) Notify(event *events.ToDoEvent) bool {
+	p.record(event)
+	return true
+}
+
+// record is also subscribed to event managers, as a handler
+func (p *recordingPublisher) record(event *events.ToDoEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.types = append(p.types, event.EventID.String())
+}
+
+// published returns the types of the events recorded so far, and
+// forgets them
+func (p *recordingPublisher) published() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	types := strings.Join(p.types, ",")
+	p.types = nil
+	return types
+}
+
+// routeTest is one request, and the events it must publish when
+// eventing is on, for example "add,add" for two add events
+type routeTest struct {
+	method     string
+	path       string
+	body       string
+	wantStatus int
+	wantEvents string
+}
+
+// routeTests has a request for every route that works with todos, they
+// expect the todos from seed
+var routeTests = []routeTest{
+	{http.MethodGet, "/todo", "", http.StatusOK, "query"},
+	{http.MethodGet, "/todo?limit=1", "", http.StatusOK, "query"},
+	{http.MethodGet, "/v2/todo?done=false", "", http.StatusOK, ""},
+	{http.MethodGet, "/todo/export?format=json", "", http.StatusOK, ""},
+	{http.MethodGet, "/todo/1", "", http.StatusOK, "query"},
+	{http.MethodPost, "/todo", `{"title": "learn docker"}`, http.StatusCreated, "add"},
+	{http.MethodPut, "/todo", `{"id": 1, "title": "learn more go"}`, http.StatusOK, "update"},
+	{http.MethodPatch, "/todo/1", `{"done": true}`, http.StatusOK, "update"},
+	{http.MethodPost, "/todo/1/complete", "", http.StatusOK, "update"},
+	{http.MethodPost, "/todo/2/reopen", "", http.StatusOK, "update"},
+	{http.MethodPost, "/todo/bulk?format=json", `[{"title": "learn k8s"}, {"title": "learn helm"}]`, http.StatusOK, "add,add"},
+	{http.MethodDelete, "/todo/1", "", http.StatusOK, "delete"},
+	{http.MethodDelete, "/todo", "", http.StatusOK, "delete"},
+	{http.MethodGet, "/todo/99", "", http.StatusNotFound, ""},
+}
+
+// newTestAPI returns an API on an in memory store, and a router with
+// the routes of main.go that the tests use
+func newTestAPI(t *testing.T) (*ToDoAPI, *gin.Engine) {
+	t.Helper()
+
+	td := NewWithDB(db.NewWithStore(db.NewMemoryStore()))
+	seed(t, td)
+
+	r := gin.New()
+	r.GET("/todo", td.ListAllTodos)
+	r.GET("/todo/export", td.ExportToDos)
+	r.POST("/todo/bulk", td.ImportToDos)
+	r.POST("/todo", td.AddToDo)
+	r.PUT("/todo", td.UpdateToDo)
+	r.DELETE("/todo", td.DeleteAllToDo)
+	r.DELETE("/todo/:id", td.DeleteToDo)
+	r.GET("/todo/:id", td.GetToDo)
+	r.PATCH("/todo/:id", td.PatchToDo)
+	r.POST("/todo/:id/complete", td.CompleteToDo)
+	r.POST("/todo/:id/reopen", td.ReopenToDo)
+	r.GET("/event/:enableFlag", td.EventEnabler)
+	r.Group("/v2").GET("/todo", td.ListSelectTodos)
+	return td, r
+}
+
+// seed makes the todos 1 and 2, the second one done, the only todos in
+// the store.  It goes straight to the db, so it publishes nothing
+func seed(t *testing.T, td *ToDoAPI) {
+	t.Helper()
+
+	if err := td.db.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	for _, item := range []db.ToDoItem{
+		{Id: 1, Title: "learn go"},
+		{Id: 2, Title: "learn gin", IsDone: true},
+	} {
+		if _, err := td.db.AddItem(item); err != nil {
+			t.Fatalf("AddItem(%d): %v", item.Id, err)
+		}
+	}
+}
+
+// serve sends one request to the router and checks its status
+func serve(t *testing.T, r *gin.Engine, method, path, body string, wantStatus int) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != wantStatus {
+		t.Fatalf("%s %s answered %d, want %d: %s", method, path, w.Code, wantStatus, w.Body.String())
+	}
+}
+
+// TestRoutesPublishEvents checks that every route publishes its events
+// while eventing is on
+func TestRoutesPublishEvents(t *testing.T) {
+	for _, tt := range routeTests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			td, r := newTestAPI(t)
+			publisher := &recordingPublisher{}
+			td.ConnectPublisher(publisher)
+
+			serve(t, r, tt.method, tt.path, tt.body, tt.wantStatus)
+			if got := publisher.published(); got != tt.wantEvents {
+				t.Fatalf("published [%s], want [%s]", got, tt.wantEvents)
+			}
+		})
+	}
+}
+
+// TestRoutesWithNopPublisherPublishNothing turns eventing off with
+// ConnectPublisher(nil), which puts a NopPublisher in place.  The
+// routes must keep working, and the publisher that was there before
+// must not get any more events
+func TestRoutesWithNopPublisherPublishNothing(t *testing.T) {
+	for _, tt := range routeTests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			td, r := newTestAPI(t)
+			if _, ok := td.publisher.(events.NopPublisher); !ok {
+				t.Fatalf("a new API publishes to a %T, want a NopPublisher", td.publisher)
+			}
+
+			publisher := &recordingPublisher{}
+			td.ConnectPublisher(publisher)
+			td.ConnectPublisher(nil)
+			if _, ok := td.publisher.(events.NopPublisher); !ok {
+				t.Fatalf("ConnectPublisher(nil) left a %T, want a NopPublisher", td.publisher)
+			}
+
+			serve(t, r, tt.method, tt.path, tt.body, tt.wantStatus)
+			if got := publisher.published(); got != "" {
+				t.Fatalf("published [%s] with eventing off", got)
+			}
+		})
+	}
+}
+
+// TestEventEnablerSwitchesPublishing connects an event manager, turns
+// it off with GET /event/false and back on with GET /event/true.  While
+// it is off a route must not publish anything, once it is back on the
+// route publishes its events again
+func TestEventEnablerSwitchesPublishing(t *testing.T) {
+	for _, tt := range routeTests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			td, r := newTestAPI(t)
+			recorder := &recordingPublisher{}
+			eventManager := events.NewToDoEventManager()
+			for _, eventType := range events.EventTypes {
+				eventManager.Subscribe(eventType, recorder.record)
+			}
+			td.ConnectEventListener(eventManager)
+			eventManager.Start()
+			t.Cleanup(td.StopEventListener)
+
+			serve(t, r, http.MethodGet, "/event/false", "", http.StatusOK)
+			serve(t, r, tt.method, tt.path, tt.body, tt.wantStatus)
+			serve(t, r, http.MethodGet, "/event/true", "", http.StatusOK)
+			if got := recorder.published(); got != "" {
+				t.Fatalf("published [%s] while eventing was off", got)
+			}
+
+			//Stopping the event manager hands every queued event to the
+			//handlers first, so once /event/false answered the recorder
+			//has every event the route published
+			seed(t, td)
+			serve(t, r, tt.method, tt.path, tt.body, tt.wantStatus)
+			serve(t, r, http.MethodGet, "/event/false", "", http.StatusOK)
+			if got := recorder.published(); got != tt.wantEvents {
+				t.Fatalf("published [%s] after eventing was turned back on, want [%s]", got, tt.wantEvents)
+			}
+		})
+	}
+}
+
+// TestEventEnablerWithoutEventManager checks that /event/:enableFlag
+// answers 409 when there is no event manager to start or stop, and 400
+// when the flag is not a bool
+func TestEventEnablerWithoutEventManager(t *testing.T) {
+	td, r := newTestAPI(t)
+	serve(t, r, http.MethodGet, "/event/true", "", http.StatusConflict)
+
+	td.ConnectPublisher(&recordingPublisher{})
+	serve(t, r, http.MethodGet, "/event/false", "", http.StatusConflict)
+
+	td.ConnectEventListener(events.NewToDoEventManager())
+	serve(t, r, http.MethodGet, "/event/maybe", "", http.StatusBadRequest)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/todo-events/events"
+	"drexel.edu/todo-lib/web"
+	"github.com/gin-gonic/gin"
+)
+
+// GET /todo/stream sends the changes to the todos as Server-Sent Events,
+// see https://html.spec.whatwg.org/multipage/server-sent-events.html.
+// Every change is one event, for example:
+//
+//	id: 7
+//	event: update
+//	data: {"todoItem":{"id":3,"title":"Learn Go","done":true,...}}
+//
+// A browser gets them with new EventSource("/todo/stream"), and when the
+// connection drops it reconnects by itself, sending the id of the last
+// event it got in the Last-Event-ID header so we can send the ones it
+// missed
+
+// streamKeepAlive is how often we send a comment down a stream that has
+// no events, so proxies do not close it for being idle
+const streamKeepAlive = 15 * time.Second
+
+// streamRetry is how long a browser waits before it reconnects, in
+// milliseconds
+const streamRetry = 3000
+
+// implementation for GET /todo/stream?type=add,update,delete
+// streams the changes to the todos until the client goes away.  The
+// type query parameter picks the types of events to get, all of them if
+// it is not there.  Last-Event-ID, or the lastEventId query parameter
+// for clients that cannot set headers, resumes a stream
+func (td *ToDoAPI) StreamToDos(c *gin.Context) {
+	if td.stream == nil {
+		web.AbortWithError(c, http.StatusConflict, web.CodeConflict, "Error streaming events",
+			errors.New("eventing is not set up, there is no event manager"))
+		return
+	}
+
+	types, err := streamTypes(c)
+	if err != nil {
+		web.AbortWithError(c, http.StatusBadRequest, web.CodeBadRequest, "Error reading event types", err)
+		return
+	}
+	lastId, err := lastEventId(c)
+	if err != nil {
+		web.AbortWithError(c, http.StatusBadRequest, web.CodeBadRequest, "Error reading Last-Event-ID", err)
+		return
+	}
+
+	missed, client := td.stream.Subscribe(lastId, types)
+	defer td.stream.Unsubscribe(client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	//Tells nginx not to buffer the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if _, err := fmt.Fprintf(c.Writer, "retry: %d\n\n", streamRetry); err != nil {
+		return
+	}
+	for _, event := range missed {
+		if err := writeStreamEvent(c.Writer, event); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-client.Events():
+			//The stream dropped us, the client reconnects and picks up
+			//the events it missed from the history
+			if !ok {
+				return
+			}
+			if err := writeStreamEvent(c.Writer, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeStreamEvent writes one event in the Server-Sent Events format,
+// the data is the JSON of the event data on a single line
+func writeStreamEvent(w io.Writer, event events.StreamEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data)
+	return err
+}
+
+// streamTypes reads the type query parameter, a comma separated list
+// of event types that can also be repeated, like ?type=add&type=delete
+func streamTypes(c *gin.Context) ([]events.EventIDType, error) {
+	var types []events.EventIDType
+	for _, param := range c.QueryArray("type") {
+		for _, name := range strings.Split(param, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			eventType, ok := streamTypeNamed(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown event type %q, must be add, update or delete", name)
+			}
+			types = append(types, eventType)
+		}
+	}
+	return types, nil
+}
+
+// streamTypeNamed returns the event type of a stream with the name
+// provided
+func streamTypeNamed(name string) (events.EventIDType, bool) {
+	for _, eventType := range events.StreamTypes {
+		if eventType.String() == name {
+			return eventType, true
+		}
+	}
+	return 0, false
+}
+
+// lastEventId reads the id of the last event a client got, 0 if it did
+// not send one
+func lastEventId(c *gin.Context) (uint64, error) {
+	value := strings.TrimSpace(c.GetHeader("Last-Event-ID"))
+	if value == "" {
+		value = c.Query("lastEventId")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("event id must be a number, got %q", value)
+	}
+	return id, nil
+}
@@ -0,0 +1,456 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"drexel.edu/todo-events/events"
+	"drexel.edu/todo-lib/db"
+	"drexel.edu/todo-lib/web"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// GET /ws keeps a client in sync with the todos over a WebSocket.  The
+// client gets the same events as GET /todo/stream, and it can change
+// todos by sending commands.  Every message is a JSON object, commands
+// look like this:
+//
+//	{"op": "add", "ref": "1", "item": {"title": "Learn Go"}}
+//	{"op": "update", "ref": "2", "item": {"id": 3, "title": "Learn Go"}, "version": 2}
+//	{"op": "delete", "ref": "3", "id": 3}
+//
+// version is optional, it works like the If-Match header.  ref is
+// picked by the client, the answer to the command has the same ref:
+//
+//	{"type": "result", "ref": "1", "status": 201, "item": {"id": 3, ...}}
+//	{"type": "error", "ref": "2", "status": 412, "error": {"code": "precondition_failed", ...}}
+//
+// and the events look like this:
+//
+//	{"type": "event", "id": 7, "event": "update", "data": {"todoItem": {...}}}
+//
+// Commands go through the same db and the same event manager as the
+// HTTP API, so the client also gets the event for its own change.
+//
+// The events a client missed are written to it first, at the pace it
+// reads them.  After that every connection has a buffer of messages
+// waiting to be sent.  A client that does not read fast enough to keep
+// it from filling up is disconnected with close code 1013 (try again
+// later), it can reconnect with the id of the last event it got, like a
+// stream, and carry on
+
+// These are the limits and timeouts of a WebSocket connection
+const (
+	// wsSendBuffer is how many messages can wait to be sent to a client
+	wsSendBuffer = 64
+	// wsMaxCommandBytes bounds the size of a command
+	wsMaxCommandBytes = 1 << 20
+	// wsWriteTimeout is how long writing one message can take
+	wsWriteTimeout = 10 * time.Second
+	// wsPongTimeout is how long a client can go without answering our
+	// pings before we give up on it
+	wsPongTimeout = 60 * time.Second
+	// wsPingInterval is how often we ping the client, it must be less
+	// than wsPongTimeout
+	wsPingInterval = wsPongTimeout * 9 / 10
+)
+
+// These are the ops of the commands a client can send
+const (
+	wsOpAdd    = "add"
+	wsOpUpdate = "update"
+	wsOpDelete = "delete"
+)
+
+// These are the types of the messages we send
+const (
+	wsTypeEvent  = "event"
+	wsTypeResult = "result"
+	wsTypeError  = "error"
+)
+
+var (
+	wsConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "todo_ws_connections",
+		Help: "Number of clients connected to the WebSocket.",
+	})
+
+	wsEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "todo_ws_evictions_total",
+		Help: "Number of WebSocket clients disconnected because they could not keep up.",
+	})
+)
+
+// wsUpgrader turns a GET /ws request into a WebSocket, SyncToDos sets
+// its CheckOrigin to checkOrigin
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// AllowOrigins lets pages from the origins provided, for example
+// https://todo.example.com, open the WebSocket on GET /ws, "*" lets
+// every page open it.  Pages served by the API itself can always open
+// it, main.go passes the origins of -allow-origin
+func (td *ToDoAPI) AllowOrigins(origins ...string) {
+	td.allowedOrigins = origins
+}
+
+// checkOrigin is the CheckOrigin of the upgrader.  CORS does not apply
+// to WebSockets, a page from any site can open one, with the cookies of
+// the user, so we check the Origin header the browser sends ourselves.
+// Just like the default of gorilla/websocket, a request without an
+// Origin does not come from a browser and is let in, and so is a page
+// from the same host as the API
+func (td *ToDoAPI) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range td.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsCommand is a command sent by a client
+type wsCommand struct {
+	Op      string          `json:"op"`
+	Ref     string          `json:"ref,omitempty"`
+	Item    json.RawMessage `json:"item,omitempty"`
+	Id      int             `json:"id,omitempty"`
+	Version *int            `json:"version,omitempty"`
+}
+
+// wsMessage is a message we send to a client, Type says which of the
+// other fields are set
+type wsMessage struct {
+	Type string `json:"type"`
+
+	//event
+	Id    uint64         `json:"id,omitempty"`
+	Event string         `json:"event,omitempty"`
+	Data  map[string]any `json:"data,omitempty"`
+
+	//result and error
+	Ref    string           `json:"ref,omitempty"`
+	Status int              `json:"status,omitempty"`
+	Item   *db.ToDoItem     `json:"item,omitempty"`
+	Error  *web.ErrorDetail `json:"error,omitempty"`
+}
+
+// wsClient is one WebSocket connection.  Only writeLoop writes to the
+// connection, everyone else queues their messages on send.  done is
+// closed when the connection has to go, closeCode and closeText say
+// why, and writeLoop sends them to the client in a close message
+type wsClient struct {
+	conn      *websocket.Conn
+	requestId string
+	send      chan []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+	closeCode int
+	closeText string
+}
+
+// implementation for GET /ws?type=add,update,delete
+// upgrades the request to a WebSocket that sends the changes to the
+// todos, and takes add, update and delete commands, until the client
+// goes away.  The type, Last-Event-ID and lastEventId parameters work
+// like they do for GET /todo/stream
+func (td *ToDoAPI) SyncToDos(c *gin.Context) {
+	if td.stream == nil {
+		web.AbortWithError(c, http.StatusConflict, web.CodeConflict, "Error opening WebSocket",
+			errors.New("eventing is not set up, there is no event manager"))
+		return
+	}
+
+	types, err := streamTypes(c)
+	if err != nil {
+		web.AbortWithError(c, http.StatusBadRequest, web.CodeBadRequest, "Error reading event types", err)
+		return
+	}
+	lastId, err := lastEventId(c)
+	if err != nil {
+		web.AbortWithError(c, http.StatusBadRequest, web.CodeBadRequest, "Error reading Last-Event-ID", err)
+		return
+	}
+
+	//If the upgrade fails the upgrader already sent the error back,
+	//a page from an origin we do not allow gets a 403
+	upgrader := wsUpgrader
+	upgrader.CheckOrigin = td.checkOrigin
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("[%s] Error opening WebSocket: %v", web.GetRequestId(c), err)
+		return
+	}
+	wsConnections.Inc()
+	defer wsConnections.Dec()
+
+	client := &wsClient{
+		conn:      conn,
+		requestId: web.GetRequestId(c),
+		send:      make(chan []byte, wsSendBuffer),
+		done:      make(chan struct{}),
+	}
+
+	missed, subscription := td.stream.Subscribe(lastId, types)
+	defer td.stream.Unsubscribe(subscription)
+
+	go client.writeLoop(missed)
+	go client.forwardEvents(subscription)
+	td.readCommands(client)
+}
+
+// readCommands reads the commands of the client and runs them, until
+// the connection is closed
+func (td *ToDoAPI) readCommands(client *wsClient) {
+	client.conn.SetReadLimit(wsMaxCommandBytes)
+	client.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	client.conn.SetPongHandler(func(string) error {
+		return client.conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		messageType, data, err := client.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("[%s] Error reading from WebSocket: %v", client.requestId, err)
+			}
+			client.close(websocket.CloseNormalClosure, "")
+			return
+		}
+
+		if messageType != websocket.TextMessage {
+			client.queue(client.errorMessage("", http.StatusBadRequest, web.CodeBadRequest,
+				errors.New("commands must be JSON text messages")))
+			continue
+		}
+		client.queue(td.runCommand(client, data))
+	}
+}
+
+// runCommand runs one command, and returns the answer to it
+func (td *ToDoAPI) runCommand(client *wsClient, data []byte) wsMessage {
+	var cmd wsCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return client.errorMessage("", http.StatusBadRequest, web.CodeBadRequest,
+			fmt.Errorf("a command must be a JSON object: %w", err))
+	}
+
+	version := db.AnyVersion
+	if cmd.Version != nil {
+		version = *cmd.Version
+	}
+
+	var item db.ToDoItem
+	var err error
+	switch cmd.Op {
+	case wsOpAdd, wsOpUpdate:
+		if len(cmd.Item) == 0 {
+			return client.errorMessage(cmd.Ref, http.StatusBadRequest, web.CodeBadRequest,
+				fmt.Errorf("an %s command must have an item", cmd.Op))
+		}
+		item, err = db.DecodeItem(cmd.Item)
+		if err != nil && !errors.Is(err, db.ErrInvalidItem) {
+			return client.errorMessage(cmd.Ref, http.StatusBadRequest, web.CodeBadRequest, err)
+		}
+		if err != nil {
+			break
+		}
+		if cmd.Op == wsOpAdd {
+			item, err = td.addItem(item)
+		} else {
+			item, err = td.updateItem(item, version)
+		}
+	case wsOpDelete:
+		if cmd.Id < 1 {
+			return client.errorMessage(cmd.Ref, http.StatusBadRequest, web.CodeBadRequest,
+				fmt.Errorf("id must be a positive number, got %d", cmd.Id))
+		}
+		err = td.deleteItem(cmd.Id, version)
+	default:
+		return client.errorMessage(cmd.Ref, http.StatusBadRequest, web.CodeBadRequest,
+			fmt.Errorf("unknown op %q, must be add, update or delete", cmd.Op))
+	}
+	if err != nil {
+		status, detail := web.DbErrorDetail(fmt.Sprintf("Error running %s command", cmd.Op), err, client.requestId)
+		return wsMessage{Type: wsTypeError, Ref: cmd.Ref, Status: status, Error: &detail}
+	}
+
+	switch cmd.Op {
+	case wsOpAdd:
+		return wsMessage{Type: wsTypeResult, Ref: cmd.Ref, Status: http.StatusCreated, Item: &item}
+	case wsOpUpdate:
+		return wsMessage{Type: wsTypeResult, Ref: cmd.Ref, Status: http.StatusOK, Item: &item}
+	default:
+		return wsMessage{Type: wsTypeResult, Ref: cmd.Ref, Status: http.StatusOK}
+	}
+}
+
+// forwardEvents queues every event of the stream, until the stream or
+// the connection drops it
+func (client *wsClient) forwardEvents(subscription *events.StreamClient) {
+	for {
+		select {
+		case <-client.done:
+			return
+		case event, ok := <-subscription.Events():
+			//The stream only drops clients that fell behind
+			if !ok {
+				client.evict()
+				return
+			}
+			if !client.queue(eventMessage(event)) {
+				return
+			}
+		}
+	}
+}
+
+// writeLoop sends the events the client missed, then the queued
+// messages, and pings the client so we find out if it went away without
+// closing the connection.  When done is closed it sends a close message
+// and closes the connection, which also ends readCommands
+func (client *wsClient) writeLoop(missed []events.StreamEvent) {
+	defer client.conn.Close()
+
+	//The history of the stream holds a lot more events than fit in
+	//send, so the missed events do not go through it.  We write them
+	//one at a time instead, a client that reads them as fast as it can
+	//is not too slow.  Live events and answers wait in send meanwhile
+	for _, event := range missed {
+		select {
+		case <-client.done:
+			client.writeClose()
+			return
+		default:
+		}
+
+		data, err := json.Marshal(eventMessage(event))
+		if err != nil {
+			log.Printf("[%s] Error encoding WebSocket message: %v", client.requestId, err)
+			continue
+		}
+		if !client.write(data) {
+			return
+		}
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case data := <-client.send:
+			if !client.write(data) {
+				return
+			}
+		case <-ping.C:
+			if err := client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				client.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-client.done:
+			client.writeClose()
+			return
+		}
+	}
+}
+
+// write sends one message, if that fails the client went away and the
+// connection is closed
+func (client *wsClient) write(data []byte) bool {
+	client.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		client.close(websocket.CloseGoingAway, "")
+		return false
+	}
+	return true
+}
+
+// writeClose sends the close code and text of the connection
+func (client *wsClient) writeClose() {
+	message := websocket.FormatCloseMessage(client.closeCode, client.closeText)
+	client.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(wsWriteTimeout))
+}
+
+// queue adds a message to the messages waiting to be sent.  If there is
+// no room for it the client is too slow and is evicted.  It returns
+// false if the message was not queued
+func (client *wsClient) queue(message wsMessage) bool {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("[%s] Error encoding WebSocket message: %v", client.requestId, err)
+		return false
+	}
+
+	select {
+	case <-client.done:
+		return false
+	default:
+	}
+
+	select {
+	case client.send <- data:
+		return true
+	default:
+		client.evict()
+		return false
+	}
+}
+
+// evict disconnects a client that cannot keep up
+func (client *wsClient) evict() {
+	if client.close(websocket.CloseTryAgainLater, "too slow, reconnect with the last event id") {
+		log.Printf("[%s] Disconnecting a WebSocket client that is too slow", client.requestId)
+		wsEvictions.Inc()
+	}
+}
+
+// close asks writeLoop to close the connection, with the close code and
+// text provided.  Only the first reason to close counts, close returns
+// false if the connection was already closing
+func (client *wsClient) close(code int, text string) bool {
+	closed := false
+	client.closeOnce.Do(func() {
+		client.closeCode = code
+		client.closeText = text
+		close(client.done)
+		closed = true
+	})
+	return closed
+}
+
+// errorMessage is the answer to a command that failed
+func (client *wsClient) errorMessage(ref string, status int, code string, err error) wsMessage {
+	log.Printf("[%s] Error running WebSocket command: %v", client.requestId, err)
+	detail := web.NewErrorDetail(code, err, client.requestId)
+	return wsMessage{Type: wsTypeError, Ref: ref, Status: status, Error: &detail}
+}
+
+// eventMessage is the message for an event of the stream
+func eventMessage(event events.StreamEvent) wsMessage {
+	return wsMessage{Type: wsTypeEvent, Id: event.Id, Event: event.Type.String(), Data: event.Data}
+}
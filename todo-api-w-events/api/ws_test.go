@@ -0,0 +1,306 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo-events/events"
+	"drexel.edu/todo-lib/db"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// TestWebSocketOrigin opens GET /ws with the Origin header a browser
+// would send.  Pages from other sites must be turned away with a 403,
+// unless AllowOrigins lets them in
+func TestWebSocketOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    int
+	}{
+		{"no origin", nil, "", http.StatusSwitchingProtocols},
+		{"our own page", nil, "http://{host}", http.StatusSwitchingProtocols},
+		{"another site", nil, "https://evil.example.com", http.StatusForbidden},
+		{"an allowed site", []string{"https://todo.example.com"}, "https://todo.example.com", http.StatusSwitchingProtocols},
+		{"a site that is not allowed", []string{"https://todo.example.com"}, "https://evil.example.com", http.StatusForbidden},
+		{"any site", []string{"*"}, "https://evil.example.com", http.StatusSwitchingProtocols},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			td := NewWithDB(db.NewWithStore(db.NewMemoryStore()))
+			eventManager := events.NewToDoEventManager()
+			td.ConnectEventListener(eventManager)
+			eventManager.Start()
+			t.Cleanup(td.StopEventListener)
+			td.AllowOrigins(tt.allowed...)
+
+			r := gin.New()
+			r.GET("/ws", td.SyncToDos)
+			server := httptest.NewServer(r)
+			t.Cleanup(server.Close)
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			header := http.Header{}
+			if tt.origin != "" {
+				header.Set("Origin", strings.Replace(tt.origin, "{host}", host, 1))
+			}
+			conn, resp, err := websocket.DefaultDialer.Dial("ws://"+host+"/ws", header)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("dialing the WebSocket: %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("opening the WebSocket answered %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+// newWSServer returns an API on an in memory store with eventing on,
+// and the host of a server that has GET /ws
+func newWSServer(t *testing.T) (*ToDoAPI, *events.ToDoEventManager, string) {
+	t.Helper()
+
+	td := NewWithDB(db.NewWithStore(db.NewMemoryStore()))
+	eventManager := events.NewToDoEventManager()
+	td.ConnectEventListener(eventManager)
+	eventManager.Start()
+	t.Cleanup(td.StopEventListener)
+
+	r := gin.New()
+	r.GET("/ws", td.SyncToDos)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return td, eventManager, strings.TrimPrefix(server.URL, "http://")
+}
+
+// wsTestClient is the client end of GET /ws.  The answers to commands
+// and the events come in on the same connection, in no set order, so
+// the events read while waiting for an answer are kept for event
+type wsTestClient struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	events []wsMessage
+}
+
+func dialWS(t *testing.T, host, query string) *wsTestClient {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+host+"/ws"+query, nil)
+	if err != nil {
+		t.Fatalf("dialing the WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &wsTestClient{t: t, conn: conn}
+}
+
+// read reads the next message
+func (c *wsTestClient) read() wsMessage {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message wsMessage
+	if err := c.conn.ReadJSON(&message); err != nil {
+		c.t.Fatalf("reading from the WebSocket: %v", err)
+	}
+	return message
+}
+
+// command sends a command and returns the answer to it
+func (c *wsTestClient) command(ref, command string) wsMessage {
+	c.t.Helper()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(command)); err != nil {
+		c.t.Fatalf("sending %s: %v", command, err)
+	}
+	for {
+		message := c.read()
+		if message.Type == wsTypeEvent {
+			c.events = append(c.events, message)
+			continue
+		}
+		if message.Ref != ref {
+			c.t.Fatalf("got an answer to %q while waiting for the answer to %q: %+v", message.Ref, ref, message)
+		}
+		return message
+	}
+}
+
+// event returns the next event
+func (c *wsTestClient) event() wsMessage {
+	c.t.Helper()
+
+	if len(c.events) > 0 {
+		message := c.events[0]
+		c.events = c.events[1:]
+		return message
+	}
+	for {
+		message := c.read()
+		if message.Type == wsTypeEvent {
+			return message
+		}
+		c.t.Fatalf("got an answer to %q while waiting for an event: %+v", message.Ref, message)
+	}
+}
+
+// TestWebSocketCommands sends the add, update and delete commands, every
+// one of them must be answered, and the client must get the event for
+// every change it made
+func TestWebSocketCommands(t *testing.T) {
+	_, _, host := newWSServer(t)
+	client := dialWS(t, host, "")
+
+	added := client.command("1", `{"op": "add", "ref": "1", "item": {"title": "learn websockets"}}`)
+	if added.Type != wsTypeResult || added.Status != http.StatusCreated || added.Item == nil {
+		t.Fatalf("the add was answered with %+v, want a 201 result with the item", added)
+	}
+	id := added.Item.Id
+
+	updated := client.command("2", fmt.Sprintf(`{"op": "update", "ref": "2", "item": {"id": %d, "title": "learn more websockets"}, "version": 1}`, id))
+	if updated.Type != wsTypeResult || updated.Status != http.StatusOK || updated.Item.Title != "learn more websockets" {
+		t.Fatalf("the update was answered with %+v, want a 200 result with the new title", updated)
+	}
+
+	stale := client.command("3", fmt.Sprintf(`{"op": "update", "ref": "3", "item": {"id": %d, "title": "too late"}, "version": 1}`, id))
+	if stale.Type != wsTypeError || stale.Status != http.StatusPreconditionFailed {
+		t.Fatalf("the update of an old version was answered with %+v, want a 412 error", stale)
+	}
+
+	deleted := client.command("4", fmt.Sprintf(`{"op": "delete", "ref": "4", "id": %d}`, id))
+	if deleted.Type != wsTypeResult || deleted.Status != http.StatusOK {
+		t.Fatalf("the delete was answered with %+v, want a 200 result", deleted)
+	}
+
+	unknown := client.command("5", `{"op": "rename", "ref": "5"}`)
+	if unknown.Type != wsTypeError || unknown.Status != http.StatusBadRequest {
+		t.Fatalf("an unknown op was answered with %+v, want a 400 error", unknown)
+	}
+
+	for _, want := range []string{"add", "update", "delete"} {
+		if event := client.event(); event.Event != want {
+			t.Fatalf("got a %s event, want %s", event.Event, want)
+		}
+	}
+}
+
+// TestWebSocketTypeFilter asks for the delete events only, the add that
+// comes before the delete must not be sent
+func TestWebSocketTypeFilter(t *testing.T) {
+	_, _, host := newWSServer(t)
+	client := dialWS(t, host, "?type=delete")
+
+	added := client.command("1", `{"op": "add", "ref": "1", "item": {"title": "learn websockets"}}`)
+	if added.Type != wsTypeResult {
+		t.Fatalf("the add was answered with %+v", added)
+	}
+	client.command("2", fmt.Sprintf(`{"op": "delete", "ref": "2", "id": %d}`, added.Item.Id))
+
+	if event := client.event(); event.Event != "delete" {
+		t.Fatalf("got a %s event, want only delete events", event.Event)
+	}
+}
+
+// TestWebSocketResume connects with the id of the last event the client
+// saw, it must get every event after it, in order.  There are far more
+// of them than fit in the send buffer of the connection, and a client
+// that reads them must not be disconnected for it
+func TestWebSocketResume(t *testing.T) {
+	td, eventManager, host := newWSServer(t)
+
+	const added = events.DefaultStreamHistory - 6
+	for i := 1; i <= added; i++ {
+		if _, err := td.addItem(db.ToDoItem{Title: fmt.Sprintf("todo %d", i)}); err != nil {
+			t.Fatalf("addItem: %v", err)
+		}
+	}
+	//Stop hands every queued event to the stream before it returns
+	eventManager.Stop()
+	eventManager.Start()
+
+	tests := []struct {
+		name   string
+		lastId uint64
+		first  uint64
+	}{
+		{"a recent event", 10, 11},
+		{"an event of an earlier run", 100000, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dialWS(t, host, fmt.Sprintf("?lastEventId=%d", tt.lastId))
+			for want := tt.first; want <= added; want++ {
+				if event := client.event(); event.Id != want {
+					t.Fatalf("got event %d, want %d", event.Id, want)
+				}
+			}
+
+			//The client is still connected, it gets the answer to a
+			//command
+			answer := client.command("1", `{"op": "add", "ref": "1", "item": {"title": "one more"}}`)
+			if answer.Type != wsTypeResult {
+				t.Fatalf("the add was answered with %+v", answer)
+			}
+		})
+	}
+}
+
+// TestWebSocketSlowClientEvicted fills the send buffer of a connection
+// before anything is written to it, the next message must disconnect
+// the client with close code 1013
+func TestWebSocketSlowClientEvicted(t *testing.T) {
+	clients := make(chan *wsClient, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		clients <- &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer), done: make(chan struct{})}
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dialing the WebSocket: %v", err)
+	}
+	defer conn.Close()
+	client := <-clients
+
+	message := wsMessage{Type: wsTypeEvent, Id: 1, Event: "add"}
+	for i := 0; i < wsSendBuffer; i++ {
+		if !client.queue(message) {
+			t.Fatalf("message %d did not fit in the send buffer", i+1)
+		}
+	}
+	if client.queue(message) {
+		t.Fatalf("a message was queued in a full send buffer")
+	}
+
+	go client.writeLoop(nil)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err == nil {
+			var got wsMessage
+			if err := json.Unmarshal(data, &got); err != nil || got.Type != wsTypeEvent {
+				t.Fatalf("got %s before the close, want the queued events", data)
+			}
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+			t.Fatalf("the connection ended with %v, want close code %d", err, websocket.CloseTryAgainLater)
+		}
+		return
+	}
+}
@@ -10,6 +10,35 @@ const (
 	ToDoErrorEvent
 )
 
+// EventTypes lists every type of event, handy to subscribe a handler to
+// all of them
+var EventTypes = []EventIDType{
+	ToDoQueryEvent,
+	ToDoAddEvent,
+	ToDoUpdateEvent,
+	ToDoDeleteEvent,
+	ToDoErrorEvent,
+}
+
+// String returns the name of the event type, it is used in logs and
+// as the type label of the event metrics
+func (id EventIDType) String() string {
+	switch id {
+	case ToDoQueryEvent:
+		return "query"
+	case ToDoAddEvent:
+		return "add"
+	case ToDoUpdateEvent:
+		return "update"
+	case ToDoDeleteEvent:
+		return "delete"
+	case ToDoErrorEvent:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
 type ToDoEvent struct {
 	EventID   EventIDType
 	EventData map[string]any
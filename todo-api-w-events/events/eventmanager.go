@@ -4,90 +4,371 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sync"
 )
 
+// EventHandler is called by the event loop with every event of the
+// type it was subscribed to
+type EventHandler func(event *ToDoEvent)
+
+// SubscriptionID identifies a subscription, pass it to Unsubscribe to
+// stop getting events
+type SubscriptionID uint64
+
+// subscription is one handler that was subscribed to a type of event
+type subscription struct {
+	id      SubscriptionID
+	handler EventHandler
+}
+
+// OverflowPolicy says what Notify does when the queue of the event
+// manager is full, because the event loop cannot keep up
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Notify wait until there is room in the queue,
+	// no event is ever dropped but a slow handler slows the API down
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest throws away the event that has been waiting the
+	// longest to make room for the new one
+	OverflowDropOldest
+	// OverflowDropNewest throws away the new event, the queue is left as
+	// it is
+	OverflowDropNewest
+)
+
+// overflowPolicyNames are the names of the policies, used on the
+// command line and in the log
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowBlock:      "block",
+	OverflowDropOldest: "drop-oldest",
+	OverflowDropNewest: "drop-newest",
+}
+
+func (p OverflowPolicy) String() string {
+	if name, ok := overflowPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// ParseOverflowPolicy returns the policy with the name provided, one of
+// block, drop-oldest or drop-newest
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	for policy, policyName := range overflowPolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q, must be one of block, drop-oldest or drop-newest", name)
+}
+
+// DefaultQueueSize is how many events can wait for the event loop if no
+// other size is provided
+const DefaultQueueSize = 1024
+
+// Options configure a ToDoEventManager, see NewToDoEventManagerWithOptions
+type Options struct {
+	// QueueSize is how many events can wait to be picked up by the event
+	// loop before Overflow kicks in, DefaultQueueSize if it is 0
+	QueueSize int
+	// Overflow is what Notify does when the queue is full
+	Overflow OverflowPolicy
+	// DrainOnStop makes Stop hand every event still in the queue to the
+	// handlers before it returns.  Without it those events wait in the
+	// queue until the event manager is started again
+	DrainOnStop bool
+}
+
+// DefaultOptions are the options NewToDoEventManager uses, a queue of
+// DefaultQueueSize that blocks when it is full and is drained on stop,
+// so no event is ever lost
+func DefaultOptions() Options {
+	return Options{
+		QueueSize:   DefaultQueueSize,
+		Overflow:    OverflowBlock,
+		DrainOnStop: true,
+	}
+}
+
+// ToDoEventManager runs an event loop in its own goroutine, events that
+// are handed to Notify are passed to every handler subscribed to their
+// type, in the order the handlers were subscribed.
+//
+// Events wait for the event loop in a buffered queue, so Notify does not
+// have to wait for the loop unless the queue is full, then the overflow
+// policy decides what happens.
+//
+// mu guards the state of the loop.  Notify holds the read lock while it
+// queues an event, and Start and Stop hold the write lock, so once Stop
+// has the lock no new event can get into the queue.  A Notify that has
+// to wait for room with OverflowBlock does not hold the lock while it
+// waits, it would hold up Stop and every Notify behind it.  It counts
+// itself in sending instead, and gives up when Stop closes stopping, so
+// Stop waits for sending before it stops the event loop.  done is closed
+// by the event loop when it returns.
+//
+// Handlers can be subscribed and unsubscribed at any time, from any
+// goroutine, subsMu guards the subscribers.  The slices in subscribers
+// are never changed in place, they are replaced, so the event loop can
+// keep using a slice it got without holding the lock
 type ToDoEventManager struct {
-	ctx      context.Context
+	options Options
+	queue   chan *ToDoEvent
+
+	mu       sync.RWMutex
 	cancel   context.CancelFunc
-	queue    chan *ToDoEvent
+	done     chan struct{}
+	stopping chan struct{}
+	sending  sync.WaitGroup
 	isActive bool
+
+	subsMu      sync.RWMutex
+	subscribers map[EventIDType][]subscription
+	lastSubId   SubscriptionID
 }
 
+// NewToDoEventManager returns an event manager with the DefaultOptions,
+// call Start to get events flowing
 func NewToDoEventManager() *ToDoEventManager {
+	return NewToDoEventManagerWithOptions(DefaultOptions())
+}
+
+// NewToDoEventManagerWithOptions returns an event manager configured by
+// the options provided, call Start to get events flowing
+func NewToDoEventManagerWithOptions(options Options) *ToDoEventManager {
+	if options.QueueSize <= 0 {
+		options.QueueSize = DefaultQueueSize
+	}
 	return &ToDoEventManager{
-		ctx:      nil,
-		cancel:   nil,
-		queue:    make(chan *ToDoEvent),
-		isActive: false,
+		options:     options,
+		queue:       make(chan *ToDoEvent, options.QueueSize),
+		isActive:    false,
+		subscribers: make(map[EventIDType][]subscription),
+	}
+}
+
+// Subscribe registers handler to be called with every event of the type
+// provided.  Any number of handlers can be subscribed to the same type
+// of event, and the same handler can be subscribed to many types.  Keep
+// the id that is returned to unsubscribe later
+func (em *ToDoEventManager) Subscribe(eventType EventIDType, handler EventHandler) SubscriptionID {
+	em.subsMu.Lock()
+	defer em.subsMu.Unlock()
+
+	em.lastSubId++
+	subs := em.subscribers[eventType]
+	//The full slice expression makes append copy the slice, see the
+	//comment on ToDoEventManager
+	em.subscribers[eventType] = append(subs[:len(subs):len(subs)], subscription{
+		id:      em.lastSubId,
+		handler: handler,
+	})
+	return em.lastSubId
+}
+
+// Unsubscribe stops the handler with the id provided from getting any
+// more events.  It returns false if there is no such subscription, for
+// example because it was already unsubscribed
+func (em *ToDoEventManager) Unsubscribe(id SubscriptionID) bool {
+	em.subsMu.Lock()
+	defer em.subsMu.Unlock()
+
+	for eventType, subs := range em.subscribers {
+		for i, sub := range subs {
+			if sub.id != id {
+				continue
+			}
+
+			remaining := make([]subscription, 0, len(subs)-1)
+			remaining = append(remaining, subs[:i]...)
+			remaining = append(remaining, subs[i+1:]...)
+			em.subscribers[eventType] = remaining
+			return true
+		}
 	}
+	return false
 }
 
+// Start starts the event loop, it does nothing if the loop is already
+// running.  Events that were left in the queue by Stop are picked up
+// where they were left
 func (em *ToDoEventManager) Start() {
-	if !em.isActive {
-		em.ctx, em.cancel = context.WithCancel(context.Background())
-		em.isActive = true
-		go em.eventLoop()
+	em.mu.Lock()
+	defer em.mu.Unlock()
+
+	if em.isActive {
+		return
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	em.cancel = cancel
+	em.done = make(chan struct{})
+	em.stopping = make(chan struct{})
+	em.isActive = true
+	go em.eventLoop(ctx, em.done)
 }
 
-func (em *ToDoEventManager) eventLoop() {
-	log.Println("Starting Event Loop...")
+func (em *ToDoEventManager) eventLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	log.Printf("Starting Event Loop, queue size %d, overflow policy %s...",
+		em.options.QueueSize, em.options.Overflow)
 	for {
 		select {
-		case <-em.ctx.Done():
+		case <-ctx.Done():
+			if em.options.DrainOnStop {
+				em.drain()
+			}
 			log.Println("Stopping Event Manager...")
 			return
 		case event := <-em.queue:
-			log.Printf("\n--> Received Event: %+v\n", event.EventData)
-			em.processEvent(event)
+			em.handleEvent(event)
 		}
 	}
 }
 
-func (em *ToDoEventManager) Stop() {
-	if em.isActive {
-		em.cancel()
-		em.isActive = false
+// drain handles every event still in the queue.  It is only called once
+// Stop made sure no more events can be queued
+func (em *ToDoEventManager) drain() {
+	for {
+		select {
+		case event := <-em.queue:
+			em.handleEvent(event)
+		default:
+			return
+		}
 	}
 }
 
-func (em *ToDoEventManager) Notify(event *ToDoEvent) {
-	if em.isActive {
-		em.queue <- event
-	}
+// handleEvent is called by the event loop with every event it takes off
+// the queue
+func (em *ToDoEventManager) handleEvent(event *ToDoEvent) {
+	eventQueueDepth.Dec()
+	log.Printf("\n--> Received Event: %+v\n", event.EventData)
+	em.processEvent(event)
+	eventsProcessed.WithLabelValues(event.EventID.String()).Inc()
 }
 
-func (em *ToDoEventManager) processEvent(event *ToDoEvent) {
-	switch event.EventID {
-	case ToDoQueryEvent:
-		em.processQueryEvent(event)
-	case ToDoAddEvent:
-		em.processAddEvent(event)
-	case ToDoUpdateEvent:
-		em.processUpdateEvent(event)
-	case ToDoDeleteEvent:
-		em.processDeleteEvent(event)
-	case ToDoErrorEvent:
-		em.processErrorEvent(event)
+// Stop stops the event loop and waits for it to return, it does nothing
+// if the loop is not running.  With DrainOnStop every event that was
+// queued before Stop was called is handled before Stop returns.  A
+// Notify that is waiting for room in the queue gives up and returns false
+func (em *ToDoEventManager) Stop() {
+	em.mu.Lock()
+	if !em.isActive {
+		em.mu.Unlock()
+		return
 	}
+	em.isActive = false
+	close(em.stopping)
+	cancel := em.cancel
+	done := em.done
+	em.mu.Unlock()
+
+	//No new Notify gets past the lock now, wait for the ones that were
+	//waiting for room, so the event they did get into the queue is
+	//there before the event loop drains it
+	em.sending.Wait()
+	cancel()
+	<-done
 }
 
-func (em *ToDoEventManager) processQueryEvent(event *ToDoEvent) {
-	fmt.Println("Processing Query Event")
+// Notify queues the event for the event loop.  It returns false if the
+// event was not queued, because the event manager is not running or
+// because the queue is full and the overflow policy is
+// OverflowDropNewest.  With OverflowDropOldest it returns true, but an
+// older event was thrown away to make room for this one.  With
+// OverflowBlock it waits for room, or returns false if Stop is called
+// first.
+//
+// A handler runs on the event loop, so a handler that notifies with
+// OverflowBlock into a full queue waits for itself, until Stop is called.
+// Use one of the drop policies, or a big enough queue, if handlers notify
+func (em *ToDoEventManager) Notify(event *ToDoEvent) bool {
+	em.mu.RLock()
+	if !em.isActive {
+		em.mu.RUnlock()
+		return false
+	}
+	if em.options.Overflow == OverflowBlock {
+		stopping := em.stopping
+		em.sending.Add(1)
+		em.mu.RUnlock()
+		defer em.sending.Done()
+		return em.queueOrStop(event, stopping)
+	}
+	defer em.mu.RUnlock()
+
+	switch em.options.Overflow {
+	case OverflowDropNewest:
+		select {
+		case em.queue <- event:
+		default:
+			eventsDropped.WithLabelValues(event.EventID.String(), OverflowDropNewest.String()).Inc()
+			return false
+		}
+	case OverflowDropOldest:
+		for !em.tryQueue(event) {
+			//The queue is full, throw away the event at its head and try
+			//again.  Another Notify can take the room we made, or the
+			//event loop can empty the queue first, so this can take more
+			//than one go
+			select {
+			case oldest := <-em.queue:
+				eventQueueDepth.Dec()
+				eventsDropped.WithLabelValues(oldest.EventID.String(), OverflowDropOldest.String()).Inc()
+			default:
+			}
+		}
+	}
+	eventQueueDepth.Inc()
+	return true
 }
 
-func (em *ToDoEventManager) processAddEvent(event *ToDoEvent) {
-	fmt.Println("Processing Add Event")
+// queueOrStop waits until there is room for the event in the queue, or
+// until stopping is closed by Stop, then the event is dropped
+func (em *ToDoEventManager) queueOrStop(event *ToDoEvent, stopping chan struct{}) bool {
+	select {
+	case em.queue <- event:
+		eventQueueDepth.Inc()
+		return true
+	case <-stopping:
+		eventsDropped.WithLabelValues(event.EventID.String(), OverflowBlock.String()).Inc()
+		return false
+	}
 }
 
-func (em *ToDoEventManager) processUpdateEvent(event *ToDoEvent) {
-	fmt.Println("Processing Update Event")
+// tryQueue queues the event if there is room for it in the queue
+func (em *ToDoEventManager) tryQueue(event *ToDoEvent) bool {
+	select {
+	case em.queue <- event:
+		return true
+	default:
+		return false
+	}
 }
 
-func (em *ToDoEventManager) processDeleteEvent(event *ToDoEvent) {
-	fmt.Println("Processing Delete Event")
+// processEvent hands the event to every handler subscribed to its type
+func (em *ToDoEventManager) processEvent(event *ToDoEvent) {
+	em.subsMu.RLock()
+	subs := em.subscribers[event.EventID]
+	em.subsMu.RUnlock()
+
+	for _, sub := range subs {
+		em.callHandler(sub, event)
+	}
 }
 
-func (em *ToDoEventManager) processErrorEvent(event *ToDoEvent) {
-	fmt.Println("Processing Error Event")
+// callHandler calls one handler.  A handler that panics is logged and
+// counted, but it does not take the event loop down with it, and the
+// other handlers still get the event
+func (em *ToDoEventManager) callHandler(sub subscription, event *ToDoEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Event handler %d panicked on a %s event: %v", sub.id, event.EventID, r)
+			eventHandlerPanics.WithLabelValues(event.EventID.String()).Inc()
+		}
+	}()
+
+	sub.handler(event)
 }
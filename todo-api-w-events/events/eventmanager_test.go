@@ -0,0 +1,353 @@
+package events
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests notify events and then Stop the event manager.  With the
+// DefaultOptions Stop hands every queued event to the handlers before it
+// returns, so once it did every handler has seen every event it will
+// ever see
+
+// recorder is a handler that remembers the events it was called with
+type recorder struct {
+	mu     sync.Mutex
+	events []*ToDoEvent
+}
+
+func (r *recorder) handle(event *ToDoEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+// ids returns the id of every event, in the order they were handled
+func (r *recorder) ids() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := make([]int, 0, len(r.events))
+	for _, event := range r.events {
+		ids = append(ids, event.EventData["id"].(int))
+	}
+	return ids
+}
+
+// holdLoop starts the event manager with the recorder subscribed to the
+// add events, and notifies add event 0.  The event loop is held up in
+// the handler of that event until the channel returned is closed, so
+// the add events notified in the meantime pile up in the queue
+func holdLoop(t *testing.T, em *ToDoEventManager, r *recorder) chan struct{} {
+	t.Helper()
+
+	held := make(chan struct{})
+	release := make(chan struct{})
+	em.Subscribe(ToDoAddEvent, func(event *ToDoEvent) {
+		r.handle(event)
+		if event.EventData["id"] == 0 {
+			close(held)
+			<-release
+		}
+	})
+
+	em.Start()
+	if !em.Notify(NewEvent(ToDoAddEvent, "id", 0)) {
+		t.Fatalf("Notify did not queue add event 0")
+	}
+	<-held
+	return release
+}
+
+// stopWithin stops the event manager, failing the test if that takes
+// longer than a few seconds
+func stopWithin(t *testing.T, em *ToDoEventManager) {
+	t.Helper()
+
+	stopped := make(chan struct{})
+	go func() {
+		em.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop did not return")
+	}
+}
+
+// notifyAll starts the event manager, notifies the events and stops it
+// again, so every event was handled when it returns
+func notifyAll(t *testing.T, em *ToDoEventManager, evnts ...*ToDoEvent) {
+	t.Helper()
+
+	em.Start()
+	for _, event := range evnts {
+		if !em.Notify(event) {
+			t.Fatalf("Notify did not queue the %s event", event.EventID)
+		}
+	}
+	em.Stop()
+}
+
+// TestSubscribersOfOneTypeAllGetTheEvent subscribes several handlers to
+// the add events, every one of them must get every add event, in the
+// order they were notified
+func TestSubscribersOfOneTypeAllGetTheEvent(t *testing.T) {
+	em := NewToDoEventManager()
+	recorders := make([]*recorder, 3)
+	for i := range recorders {
+		recorders[i] = &recorder{}
+		em.Subscribe(ToDoAddEvent, recorders[i].handle)
+	}
+
+	first := NewEvent(ToDoAddEvent, "id", 1)
+	second := NewEvent(ToDoAddEvent, "id", 2)
+	notifyAll(t, em, first, second)
+
+	for i, r := range recorders {
+		if r.count() != 2 {
+			t.Fatalf("subscriber %d got %d events, want 2", i, r.count())
+		}
+		if r.events[0] != first || r.events[1] != second {
+			t.Fatalf("subscriber %d got the events out of order", i)
+		}
+	}
+}
+
+// TestSubscriberOfAnotherTypeGetsNothing checks that a handler only
+// gets the events of the type it was subscribed to
+func TestSubscriberOfAnotherTypeGetsNothing(t *testing.T) {
+	em := NewToDoEventManager()
+	adds := &recorder{}
+	deletes := &recorder{}
+	em.Subscribe(ToDoAddEvent, adds.handle)
+	em.Subscribe(ToDoDeleteEvent, deletes.handle)
+
+	notifyAll(t, em,
+		NewEvent(ToDoAddEvent, "id", 1),
+		NewEvent(ToDoUpdateEvent, "id", 1),
+		NewEvent(ToDoQueryEvent, "id", 1))
+
+	if adds.count() != 1 {
+		t.Fatalf("the add subscriber got %d events, want 1", adds.count())
+	}
+	if deletes.count() != 0 {
+		t.Fatalf("the delete subscriber got %d events, want none", deletes.count())
+	}
+}
+
+// TestUnsubscribedHandlerGetsNoMoreEvents unsubscribes one of two
+// handlers, it must not get the events that come after that, the other
+// one must
+func TestUnsubscribedHandlerGetsNoMoreEvents(t *testing.T) {
+	em := NewToDoEventManager()
+	leaving := &recorder{}
+	staying := &recorder{}
+	id := em.Subscribe(ToDoUpdateEvent, leaving.handle)
+	em.Subscribe(ToDoUpdateEvent, staying.handle)
+
+	notifyAll(t, em, NewEvent(ToDoUpdateEvent, "id", 1))
+	if !em.Unsubscribe(id) {
+		t.Fatalf("Unsubscribe(%d) did not find the subscription", id)
+	}
+	if em.Unsubscribe(id) {
+		t.Fatalf("Unsubscribe(%d) found the subscription a second time", id)
+	}
+	notifyAll(t, em, NewEvent(ToDoUpdateEvent, "id", 2), NewEvent(ToDoUpdateEvent, "id", 3))
+
+	if leaving.count() != 1 {
+		t.Fatalf("the unsubscribed handler got %d events, want only the 1 before it left", leaving.count())
+	}
+	if staying.count() != 3 {
+		t.Fatalf("the other handler got %d events, want 3", staying.count())
+	}
+}
+
+// TestPanickingHandlerDoesNotStopDelivery subscribes a handler that
+// panics between two that do not.  Both of them must still get every
+// event, and the event loop must keep running
+func TestPanickingHandlerDoesNotStopDelivery(t *testing.T) {
+	em := NewToDoEventManager()
+	before := &recorder{}
+	after := &recorder{}
+	em.Subscribe(ToDoDeleteEvent, before.handle)
+	em.Subscribe(ToDoDeleteEvent, func(event *ToDoEvent) {
+		panic("handler failed")
+	})
+	em.Subscribe(ToDoDeleteEvent, after.handle)
+
+	notifyAll(t, em, NewEvent(ToDoDeleteEvent, "id", 1), NewEvent(ToDoDeleteEvent, "id", 2))
+
+	if before.count() != 2 || after.count() != 2 {
+		t.Fatalf("the handlers around the one that panics got %d and %d events, want 2 and 2",
+			before.count(), after.count())
+	}
+}
+
+// TestOverflowDropNewest fills the queue, the event notified after that
+// must be dropped and the ones in the queue kept
+func TestOverflowDropNewest(t *testing.T) {
+	em := NewToDoEventManagerWithOptions(Options{QueueSize: 2, Overflow: OverflowDropNewest, DrainOnStop: true})
+	r := &recorder{}
+	release := holdLoop(t, em, r)
+
+	for id := 1; id <= 2; id++ {
+		if !em.Notify(NewEvent(ToDoAddEvent, "id", id)) {
+			t.Fatalf("Notify did not queue add event %d", id)
+		}
+	}
+	if em.Notify(NewEvent(ToDoAddEvent, "id", 3)) {
+		t.Errorf("Notify queued add event 3 into a full queue")
+	}
+	close(release)
+	stopWithin(t, em)
+
+	if got, want := r.ids(), []int{0, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled add events %v, want %v", got, want)
+	}
+}
+
+// TestOverflowDropOldest fills the queue, the event notified after that
+// must take the place of the one that waited the longest
+func TestOverflowDropOldest(t *testing.T) {
+	em := NewToDoEventManagerWithOptions(Options{QueueSize: 2, Overflow: OverflowDropOldest, DrainOnStop: true})
+	r := &recorder{}
+	release := holdLoop(t, em, r)
+
+	for id := 1; id <= 3; id++ {
+		if !em.Notify(NewEvent(ToDoAddEvent, "id", id)) {
+			t.Fatalf("Notify did not queue add event %d", id)
+		}
+	}
+	close(release)
+	stopWithin(t, em)
+
+	if got, want := r.ids(), []int{0, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled add events %v, want %v", got, want)
+	}
+}
+
+// TestOverflowBlock fills the queue, the next Notify must wait until the
+// event loop made room and then queue its event, nothing is dropped
+func TestOverflowBlock(t *testing.T) {
+	em := NewToDoEventManagerWithOptions(Options{QueueSize: 2, Overflow: OverflowBlock, DrainOnStop: true})
+	r := &recorder{}
+	release := holdLoop(t, em, r)
+
+	for id := 1; id <= 2; id++ {
+		if !em.Notify(NewEvent(ToDoAddEvent, "id", id)) {
+			t.Fatalf("Notify did not queue add event %d", id)
+		}
+	}
+	queued := make(chan bool)
+	go func() {
+		queued <- em.Notify(NewEvent(ToDoAddEvent, "id", 3))
+	}()
+	select {
+	case <-queued:
+		t.Fatalf("Notify returned while the queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if !<-queued {
+		t.Fatalf("Notify did not queue add event 3 once there was room")
+	}
+	stopWithin(t, em)
+
+	if got, want := r.ids(), []int{0, 1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled add events %v, want %v", got, want)
+	}
+}
+
+// TestOverflowBlockHandlerNotifies has a handler notify into a full
+// queue.  The event loop is the one that would make room, so the
+// handler waits, but Stop must not hang on it: the Notify gives up and
+// the event that was already queued is still drained
+func TestOverflowBlockHandlerNotifies(t *testing.T) {
+	em := NewToDoEventManagerWithOptions(Options{QueueSize: 1, Overflow: OverflowBlock, DrainOnStop: true})
+	updates := &recorder{}
+	em.Subscribe(ToDoUpdateEvent, updates.handle)
+
+	queuedFirst := make(chan bool, 1)
+	queuedSecond := make(chan bool, 1)
+	em.Subscribe(ToDoAddEvent, func(event *ToDoEvent) {
+		queuedFirst <- em.Notify(NewEvent(ToDoUpdateEvent, "id", 1))
+		queuedSecond <- em.Notify(NewEvent(ToDoUpdateEvent, "id", 2))
+	})
+
+	em.Start()
+	if !em.Notify(NewEvent(ToDoAddEvent, "id", 0)) {
+		t.Fatalf("Notify did not queue add event 0")
+	}
+	if !<-queuedFirst {
+		t.Fatalf("the handler could not queue update event 1")
+	}
+	stopWithin(t, em)
+
+	if <-queuedSecond {
+		t.Errorf("Notify queued update event 2 into a full queue that nobody was emptying")
+	}
+	if got, want := updates.ids(), []int{1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled update events %v, want %v", got, want)
+	}
+}
+
+// TestDrainOnStop queues events behind a held up event loop and stops
+// it right away, every one of them must be handled before Stop returns
+func TestDrainOnStop(t *testing.T) {
+	em := NewToDoEventManagerWithOptions(Options{QueueSize: 8, Overflow: OverflowBlock, DrainOnStop: true})
+	r := &recorder{}
+	release := holdLoop(t, em, r)
+
+	for id := 1; id <= 5; id++ {
+		if !em.Notify(NewEvent(ToDoAddEvent, "id", id)) {
+			t.Fatalf("Notify did not queue add event %d", id)
+		}
+	}
+	close(release)
+	stopWithin(t, em)
+
+	if got, want := r.ids(), []int{0, 1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled add events %v, want %v", got, want)
+	}
+}
+
+// TestNoDrainOnStop does the same without DrainOnStop, the events left
+// in the queue by Stop must be picked up when the event manager is
+// started again
+func TestNoDrainOnStop(t *testing.T) {
+	em := NewToDoEventManagerWithOptions(Options{QueueSize: 8, Overflow: OverflowBlock})
+	r := &recorder{}
+	release := holdLoop(t, em, r)
+
+	for id := 1; id <= 5; id++ {
+		if !em.Notify(NewEvent(ToDoAddEvent, "id", id)) {
+			t.Fatalf("Notify did not queue add event %d", id)
+		}
+	}
+	close(release)
+	stopWithin(t, em)
+	if em.Notify(NewEvent(ToDoAddEvent, "id", 6)) {
+		t.Errorf("Notify queued an event while the event manager was stopped")
+	}
+
+	em.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.count() < 6 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stopWithin(t, em)
+
+	if got, want := r.ids(), []int{0, 1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("handled add events %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,39 @@
+package events
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// These metrics show how the event manager keeps up, they are served
+// on /metrics along with the HTTP metrics.  The queue depth counts the
+// events that were handed to Notify but not yet picked up by the event
+// loop, if it stays near the size of the queue the loop cannot keep up
+// and the overflow policy is kicking in, the dropped counter shows how
+// many events that cost
+var (
+	eventQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "todo_event_queue_depth",
+		Help: "Number of events waiting to be picked up by the event loop.",
+	})
+
+	eventsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "todo_events_processed_total",
+		Help: "Number of events processed by the event loop, by event type.",
+	}, []string{"type"})
+
+	eventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "todo_events_dropped_total",
+		Help: "Number of events dropped because the event queue was full, by event type and overflow policy.",
+	}, []string{"type", "policy"})
+
+	streamClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "todo_stream_clients",
+		Help: "Number of clients connected to the stream of todo events.",
+	})
+
+	eventHandlerPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "todo_event_handler_panics_total",
+		Help: "Number of times an event handler panicked, by event type.",
+	}, []string{"type"})
+)
@@ -0,0 +1,20 @@
+package events
+
+// Publisher is what the API hands its events to.  ToDoEventManager is a
+// Publisher, and so is NopPublisher, which the API uses when eventing
+// is not set up.  Notify returns true if the event was taken, false if
+// it was thrown away
+type Publisher interface {
+	Notify(event *ToDoEvent) bool
+}
+
+// NopPublisher throws every event away, it lets code publish events
+// without checking first if anyone is listening
+type NopPublisher struct{}
+
+func (NopPublisher) Notify(event *ToDoEvent) bool {
+	return false
+}
+
+// Make sure the event manager keeps being a Publisher
+var _ Publisher = (*ToDoEventManager)(nil)
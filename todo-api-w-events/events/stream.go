@@ -0,0 +1,189 @@
+package events
+
+import "sync"
+
+// StreamTypes are the types of events a Stream carries, the ones that
+// change todos
+var StreamTypes = []EventIDType{ToDoAddEvent, ToDoUpdateEvent, ToDoDeleteEvent}
+
+// DefaultStreamHistory is how many events a Stream keeps for clients
+// that reconnect, if no other size is provided
+const DefaultStreamHistory = 256
+
+// streamClientBuffer is how many events can wait for a client of a
+// Stream.  A client that falls further behind is dropped, it can
+// reconnect and pick up from the history
+const streamClientBuffer = 64
+
+// StreamEvent is one event of a Stream.  Ids count up from 1 in the
+// order the events happened, a client that reconnects says which id it
+// saw last to get the ones it missed
+type StreamEvent struct {
+	Id   uint64
+	Type EventIDType
+	Data map[string]any
+}
+
+// Stream fans the events of an event manager out to any number of
+// clients, like the browsers connected to GET /todo/stream.  It numbers
+// the events, and keeps the last few of them, so a client that lost its
+// connection can get the events it missed.
+//
+// The event loop calls publish, so publish must never wait on a client,
+// a client whose buffer is full is dropped instead.  mu guards
+// everything below it, holding it while an event is numbered, added to
+// the history and sent to the clients means a client that subscribes
+// gets every event exactly once, either from the history or from its
+// channel
+type Stream struct {
+	eventManager  *ToDoEventManager
+	subscriptions []SubscriptionID
+	historySize   int
+
+	mu      sync.Mutex
+	lastId  uint64
+	history []StreamEvent
+	clients map[*StreamClient]struct{}
+	closed  bool
+}
+
+// StreamClient is one client of a Stream, it gets the events of the
+// types it asked for on Events
+type StreamClient struct {
+	types  map[EventIDType]bool
+	events chan StreamEvent
+}
+
+// NewStream subscribes a Stream to the StreamTypes events of the event
+// manager provided, and keeps the last historySize of them.  If
+// historySize is 0 DefaultStreamHistory is used.  Call Close to
+// unsubscribe it
+func NewStream(eventManager *ToDoEventManager, historySize int) *Stream {
+	if historySize <= 0 {
+		historySize = DefaultStreamHistory
+	}
+
+	s := &Stream{
+		eventManager: eventManager,
+		historySize:  historySize,
+		clients:      make(map[*StreamClient]struct{}),
+	}
+	for _, eventType := range StreamTypes {
+		s.subscriptions = append(s.subscriptions, eventManager.Subscribe(eventType, s.publish))
+	}
+	return s
+}
+
+// Subscribe adds a client that gets the events of the types provided,
+// or of every one of the StreamTypes if types is empty.  The events
+// after lastId that are still in the history are returned, pass 0 to
+// get none, and every event after them goes to the Events channel of
+// the client.  Call Unsubscribe when the client goes away
+//
+// An id we have not handed out yet means the client saw the ids of an
+// earlier run of the API, so it gets the whole history
+func (s *Stream) Subscribe(lastId uint64, types []EventIDType) ([]StreamEvent, *StreamClient) {
+	client := &StreamClient{
+		types:  make(map[EventIDType]bool),
+		events: make(chan StreamEvent, streamClientBuffer),
+	}
+	if len(types) == 0 {
+		types = StreamTypes
+	}
+	for _, eventType := range types {
+		client.types[eventType] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var missed []StreamEvent
+	if lastId > 0 {
+		if lastId > s.lastId {
+			lastId = 0
+		}
+		for _, event := range s.history {
+			if event.Id > lastId && client.types[event.Type] {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	if s.closed {
+		close(client.events)
+		return missed, client
+	}
+	s.clients[client] = struct{}{}
+	streamClients.Inc()
+	return missed, client
+}
+
+// Unsubscribe removes a client, it is fine to call it for a client that
+// was already dropped
+func (s *Stream) Unsubscribe(client *StreamClient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeClient(client)
+}
+
+// Close unsubscribes the Stream from the event manager and drops every
+// client
+func (s *Stream) Close() {
+	for _, id := range s.subscriptions {
+		s.eventManager.Unsubscribe(id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for client := range s.clients {
+		s.removeClient(client)
+	}
+}
+
+// Events returns the channel the events for the client arrive on.  It
+// is closed when the client is dropped, because it fell too far behind
+// or the Stream was closed
+func (c *StreamClient) Events() <-chan StreamEvent {
+	return c.events
+}
+
+// publish is the event handler the Stream subscribes to the event
+// manager
+func (s *Stream) publish(event *ToDoEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastId++
+	streamEvent := StreamEvent{Id: s.lastId, Type: event.EventID, Data: event.EventData}
+	s.history = append(s.history, streamEvent)
+	if len(s.history) > s.historySize {
+		s.history = s.history[len(s.history)-s.historySize:]
+	}
+
+	for client := range s.clients {
+		if !client.types[event.EventID] {
+			continue
+		}
+		select {
+		case client.events <- streamEvent:
+		default:
+			//The client cannot keep up, drop it rather than hold up
+			//the event loop
+			s.removeClient(client)
+		}
+	}
+}
+
+// removeClient closes the channel of a client and forgets about it, mu
+// must be held
+func (s *Stream) removeClient(client *StreamClient) {
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	delete(s.clients, client)
+	close(client.events)
+	streamClients.Dec()
+}
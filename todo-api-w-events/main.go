@@ -4,8 +4,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"drexel.edu/todo-events/api"
+	"drexel.edu/todo-events/events"
+	"drexel.edu/todo-lib/web"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -13,8 +16,15 @@ import (
 // Global variables to hold the command line flags to drive the todo CLI
 // application
 var (
-	hostFlag string
-	portFlag uint
+	hostFlag  string
+	portFlag  uint
+	storeFlag string
+
+	eventQueueFlag    int
+	eventOverflowFlag string
+	eventDrainFlag    bool
+
+	allowOriginFlag string
 )
 
 // processCmdLineFlags parses the command line flags for our CLI
@@ -48,6 +58,24 @@ func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.UintVar(&portFlag, "p", 1080, "Default Port")
 
+	//The storage backend can be picked at startup.  If this flag is not
+	//set we will look at the TODO_STORE environment variable, and then
+	//fall back to an in memory map
+	flag.StringVar(&storeFlag, "s", "", "Storage backend: memory or file")
+
+	//Events wait for the event loop in a queue, these flags set how big
+	//it is, what happens when it is full, and if the events still in it
+	//are handled when the event manager is stopped
+	flag.IntVar(&eventQueueFlag, "event-queue", events.DefaultQueueSize, "Number of events that can wait for the event loop")
+	flag.StringVar(&eventOverflowFlag, "event-overflow", "block", "What to do when the event queue is full: block, drop-oldest or drop-newest")
+	flag.BoolVar(&eventDrainFlag, "event-drain", true, "Handle the queued events when the event manager is stopped")
+
+	//Browsers only let a page from another origin use the API if CORS
+	//says so, and only let it open the WebSocket if the API does.  By
+	//default any page can use the API, but only our own pages can open
+	//the WebSocket, this flag picks the origins that can do both
+	flag.StringVar(&allowOriginFlag, "allow-origin", "", "Comma separated origins, like https://todo.example.com, that can use the API and the WebSocket from a browser, * for any")
+
 	flag.Parse()
 }
 
@@ -57,27 +85,76 @@ func processCmdLineFlags() {
 func main() {
 	processCmdLineFlags()
 	r := gin.Default()
-	r.Use(cors.Default())
 
-	apiHandler, err := api.New()
+	//Without -allow-origin, or with * in it, CORS allows every origin
+	var origins []string
+	allowAll := false
+	for _, origin := range strings.Split(allowOriginFlag, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+			allowAll = allowAll || origin == "*"
+		}
+	}
+	corsConfig := cors.DefaultConfig()
+	if allowAll || len(origins) == 0 {
+		corsConfig.AllowAllOrigins = true
+	} else {
+		corsConfig.AllowOrigins = origins
+	}
+	if err := corsConfig.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	r.Use(cors.New(corsConfig))
+
+	apiHandler, err := api.NewWithStoreType(storeFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	apiHandler.AllowOrigins(origins...)
+
+	overflow, err := events.ParseOverflowPolicy(eventOverflowFlag)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	apiHandler.AddEventListener(events.Options{
+		QueueSize:   eventQueueFlag,
+		Overflow:    overflow,
+		DrainOnStop: eventDrainFlag,
+	})
+
+	//Give every request an id, it is sent back with errors so they
+	//can be matched up with the log
+	r.Use(web.RequestId())
 
-	apiHandler.AddEventListener()
+	//Count every request, so /health can report real numbers, and
+	//count and time them by route for prometheus
+	r.Use(apiHandler.CountRequests())
+	r.Use(web.RecordMetrics())
 
 	r.GET("/todo", apiHandler.ListAllTodos)
+	r.GET("/todo/export", apiHandler.ExportToDos)
+	r.GET("/todo/stream", apiHandler.StreamToDos)
+	r.GET("/ws", apiHandler.SyncToDos)
+	r.POST("/todo/bulk", apiHandler.ImportToDos)
 	r.POST("/todo", apiHandler.AddToDo)
 	r.PUT("/todo", apiHandler.UpdateToDo)
 	r.DELETE("/todo", apiHandler.DeleteAllToDo)
 	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
 	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.PATCH("/todo/:id", apiHandler.PatchToDo)
+	r.POST("/todo/:id/complete", apiHandler.CompleteToDo)
+	r.POST("/todo/:id/reopen", apiHandler.ReopenToDo)
 
 	//These are some extra endpoints that will be used to demonstrate
 	//a few resiliency features of GoLang Gin, and healthchecks
 	r.GET("/crash", apiHandler.CrashSim)
 	r.GET("/health", apiHandler.HealthCheck)
+	r.GET("/healthz", apiHandler.Healthz)
+	r.GET("/readyz", apiHandler.Readyz)
+	r.GET("/metrics", web.MetricsHandler())
 	r.GET("/event/:enableFlag", apiHandler.EventEnabler)
 
 	//We will now show a common way to version an API and add a new
@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"drexel.edu/todo-lib/db"
+	"drexel.edu/todo-lib/web"
 	"drexel.edu/todo/api"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -13,10 +22,17 @@ import (
 // Global variables to hold the command line flags to drive the todo CLI
 // application
 var (
-	hostFlag string
-	portFlag uint
+	hostFlag             string
+	portFlag             uint
+	storeFlag            string
+	snapshotFlag         string
+	snapshotIntervalFlag time.Duration
 )
 
+// shutdownTimeout is how long the requests in flight get to finish
+// when we are asked to stop
+const shutdownTimeout = 10 * time.Second
+
 // processCmdLineFlags parses the command line flags for our CLI
 //
 // TODO: This function uses the flag package to parse the command line
@@ -48,6 +64,18 @@ func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.UintVar(&portFlag, "p", 1080, "Default Port")
 
+	//The storage backend can be picked at startup.  If this flag is not
+	//set we will look at the TODO_STORE environment variable, and then
+	//fall back to an in memory map
+	flag.StringVar(&storeFlag, "s", "", "Storage backend: memory or file")
+
+	//The memory store loses everything when the API stops.  Providing a
+	//snapshot file keeps the items in memory, but writes them out to the
+	//file every interval, and journals every change in between.  When
+	//the API starts again it picks up where it left off
+	flag.StringVar(&snapshotFlag, "snapshot", "", "Snapshot file for the memory store, empty disables snapshots")
+	flag.DurationVar(&snapshotIntervalFlag, "snapshot-interval", db.DefaultSnapshotInterval, "How often to snapshot the memory store")
+
 	flag.Parse()
 }
 
@@ -59,21 +87,48 @@ func main() {
 	r := gin.Default()
 	r.Use(cors.Default())
 
-	apiHandler, err := api.New()
+	var apiHandler *api.ToDoAPI
+	var err error
+	if snapshotFlag != "" {
+		if storeFlag != "" && storeFlag != db.StoreMemory {
+			fmt.Println("-snapshot can only be used with the memory store")
+			os.Exit(1)
+		}
+		apiHandler, err = api.NewWithSnapshots(snapshotFlag, snapshotIntervalFlag)
+	} else {
+		apiHandler, err = api.NewWithStoreType(storeFlag)
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	//Give every request an id, it is sent back with errors so they
+	//can be matched up with the log
+	r.Use(web.RequestId())
+
+	//Count every request, so /health can report real numbers, and
+	//count and time them by route for prometheus
+	r.Use(apiHandler.CountRequests())
+	r.Use(web.RecordMetrics())
+
 	r.GET("/todo", apiHandler.ListAllTodos)
+	r.GET("/todo/export", apiHandler.ExportToDos)
+	r.POST("/todo/bulk", apiHandler.ImportToDos)
 	r.POST("/todo", apiHandler.AddToDo)
 	r.PUT("/todo", apiHandler.UpdateToDo)
 	r.DELETE("/todo", apiHandler.DeleteAllToDo)
 	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
 	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.PATCH("/todo/:id", apiHandler.PatchToDo)
+	r.POST("/todo/:id/complete", apiHandler.CompleteToDo)
+	r.POST("/todo/:id/reopen", apiHandler.ReopenToDo)
 
 	r.GET("/crash", apiHandler.CrashSim)
 	r.GET("/health", apiHandler.HealthCheck)
+	r.GET("/healthz", apiHandler.Healthz)
+	r.GET("/readyz", apiHandler.Readyz)
+	r.GET("/metrics", web.MetricsHandler())
 
 	//We will now show a common way to version an API and add a new
 	//version of an API handler under /v2.  This new API will support
@@ -81,6 +136,41 @@ func main() {
 	v2 := r.Group("/v2")
 	v2.GET("/todo", apiHandler.ListSelectTodos)
 
+	//Instead of r.Run we run the http server ourselves, so we can stop
+	//it on Ctrl-C, or when docker asks us to with SIGTERM.  The requests
+	//in flight get to finish, and then the store is closed, so the
+	//snapshot store writes out a last snapshot
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
-	r.Run(serverPath)
+	server := &http.Server{Addr: serverPath, Handler: r}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		log.Println("Error running the server: ", err)
+		exitCode = 1
+	case <-ctx.Done():
+		log.Println("Shutting down the server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("Error shutting down the server: ", err)
+			exitCode = 1
+		}
+	}
+
+	if err := apiHandler.Close(); err != nil {
+		log.Println("Error closing the store: ", err)
+		exitCode = 1
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
 }
@@ -1,368 +0,0 @@
-package db
-
-import (
-	"context"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"log"
-	"os"
-
-	"github.com/go-redis/redis/v8"
-	"github.com/nitishm/go-rejson/v4"
-)
-
-// ToDoItem is the struct that represents a single ToDo item
-type ToDoItem struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	IsDone bool   `json:"done"`
-}
-
-const (
-	RedisNilError        = "redis: nil"
-	RedisDefaultLocation = "0.0.0.0:6379"
-	RedisKeyPrefix       = "todo:"
-)
-
-type cache struct {
-	cacheClient *redis.Client
-	jsonHelper  *rejson.Handler
-	context     context.Context
-}
-
-// ToDo is the struct that represents the main object of our
-// todo app.  It contains a reference to a cache object
-type ToDo struct {
-	//more things would be included in a real implementation
-
-	//Redis cache connections
-	cache
-}
-
-// New is a constructor function that returns a pointer to a new
-// ToDo struct.  If this is called it uses the default Redis URL
-// with the companion constructor NewWithCacheInstance.
-func New() (*ToDo, error) {
-	//We will use an override if the REDIS_URL is provided as an environment
-	//variable, which is the preferred way to wire up a docker container
-	redisUrl := os.Getenv("REDIS_URL")
-	//This handles the default condition
-	if redisUrl == "" {
-		redisUrl = RedisDefaultLocation
-	}
-	return NewWithCacheInstance(redisUrl)
-}
-
-// NewWithCacheInstance is a constructor function that returns a pointer to a new
-// ToDo struct.  It accepts a string that represents the location of the redis
-// cache.
-func NewWithCacheInstance(location string) (*ToDo, error) {
-
-	//Connect to redis.  Other options can be provided, but the
-	//defaults are OK
-	client := redis.NewClient(&redis.Options{
-		Addr: location,
-	})
-
-	//We use this context to coordinate betwen our go code and
-	//the redis operaitons
-	ctx := context.Background()
-
-	//This is the reccomended way to ensure that our redis connection
-	//is working
-	err := client.Ping(ctx).Err()
-	if err != nil {
-		log.Println("Error connecting to redis" + err.Error() + "cache might not be available, continuing...")
-	}
-
-	//By default, redis manages keys and values, where the values
-	//are either strings, sets, maps, etc.  Redis has an extension
-	//module called ReJSON that allows us to store JSON objects
-	//however, we need a companion library in order to work with it
-	//Below we create an instance of the JSON helper and associate
-	//it with our redis connnection
-	jsonHelper := rejson.NewReJSONHandler()
-	jsonHelper.SetGoRedisClientWithContext(ctx, client)
-
-	//Return a pointer to a new ToDo struct
-	return &ToDo{
-		cache: cache{
-			cacheClient: client,
-			jsonHelper:  jsonHelper,
-			context:     ctx,
-		},
-	}, nil
-}
-
-//------------------------------------------------------------
-// REDIS HELPERS
-//------------------------------------------------------------
-
-// We will use this later, you can ignore for now
-func isRedisNilError(err error) bool {
-	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
-}
-
-// In redis, our keys will be strings, they will look like
-// todo:<number>.  This function will take an integer and
-// return a string that can be used as a key in redis
-func redisKeyFromId(id int) string {
-	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
-}
-
-// Helper to return a ToDoItem from redis provided a key
-func (t *ToDo) getItemFromRedis(key string, item *ToDoItem) error {
-
-	//Lets query redis for the item, note we can return parts of the
-	//json structure, the second parameter "." means return the entire
-	//json structure
-	itemObject, err := t.jsonHelper.JSONGet(key, ".")
-	if err != nil {
-		return err
-	}
-
-	//JSONGet returns an "any" object, or empty interface,
-	//we need to convert it to a byte array, which is the
-	//underlying type of the object, then we can unmarshal
-	//it into our ToDoItem struct
-	err = json.Unmarshal(itemObject.([]byte), item)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-//------------------------------------------------------------
-// THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
-//------------------------------------------------------------
-
-// AddItem accepts a ToDoItem and adds it to the DB.
-// Preconditions:   (1) The database file must exist and be a valid
-//
-//					(2) The item must not already exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
-//	    				exists in the DB, if so, return an error
-//
-// Postconditions:
-//
-//	 (1) The item will be added to the DB
-//		(2) The DB file will be saved with the item added
-//		(3) If there is an error, it will be returned
-func (t *ToDo) AddItem(item ToDoItem) error {
-
-	//Before we add an item to the DB, lets make sure
-	//it does not exist, if it does, return an error
-	redisKey := redisKeyFromId(item.Id)
-	var existingItem ToDoItem
-	if err := t.getItemFromRedis(redisKey, &existingItem); err == nil {
-		return errors.New("item already exists")
-	}
-
-	//Add item to database with JSON Set
-	if _, err := t.jsonHelper.JSONSet(redisKey, ".", item); err != nil {
-		return err
-	}
-
-	//If everything is ok, return nil for the error
-	return nil
-}
-
-// DeleteItem accepts an item id and removes it from the DB.
-// Preconditions:   (1) The database file must exist and be a valid
-//
-//					(2) The item must exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
-//	    				exists in the DB, if not, return an error
-//
-// Postconditions:
-//
-//	 (1) The item will be removed from the DB
-//		(2) The DB file will be saved with the item removed
-//		(3) If there is an error, it will be returned
-func (t *ToDo) DeleteItem(id int) error {
-
-	pattern := redisKeyFromId(id)
-	numDeleted, err := t.cacheClient.Del(t.context, pattern).Result()
-	if err != nil {
-		return err
-	}
-	if numDeleted == 0 {
-		return errors.New("attempted to delete non-existent item")
-	}
-
-	return nil
-}
-
-// DeleteAll removes all items from the DB.
-// It will be exposed via a DELETE /todo endpoint
-func (t *ToDo) DeleteAll() error {
-
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := t.cacheClient.Keys(t.context, pattern).Result()
-	//Note delete can take a collection of keys.  In go we can
-	//expand a slice into individual arguments by using the ...
-	//operator
-	numDeleted, err := t.cacheClient.Del(t.context, ks...).Result()
-	if err != nil {
-		return err
-	}
-
-	if numDeleted != int64(len(ks)) {
-		return errors.New("one or more items could not be deleted")
-	}
-
-	return nil
-}
-
-// UpdateItem accepts a ToDoItem and updates it in the DB.
-// Preconditions:   (1) The database file must exist and be a valid
-//
-//					(2) The item must exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
-//	    				exists in the DB, if not, return an error
-//
-// Postconditions:
-//
-//	 (1) The item will be updated in the DB
-//		(2) The DB file will be saved with the item updated
-//		(3) If there is an error, it will be returned
-func (t *ToDo) UpdateItem(item ToDoItem) error {
-
-	//Before we add an item to the DB, lets make sure
-	//it does not exist, if it does, return an error
-	redisKey := redisKeyFromId(item.Id)
-	var existingItem ToDoItem
-	if err := t.getItemFromRedis(redisKey, &existingItem); err != nil {
-		return errors.New("item does not exist")
-	}
-
-	//Add item to database with JSON Set.  Note there is no update
-	//functionality, so we just overwrite the existing item
-	if _, err := t.jsonHelper.JSONSet(redisKey, ".", item); err != nil {
-		return err
-	}
-
-	//If everything is ok, return nil for the error
-	return nil
-}
-
-// GetItem accepts an item id and returns the item from the DB.
-// Preconditions:   (1) The database file must exist and be a valid
-//
-//					(2) The item must exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
-//	    				exists in the DB, if not, return an error
-//
-// Postconditions:
-//
-//	 (1) The item will be returned, if it exists
-//		(2) If there is an error, it will be returned
-//			along with an empty ToDoItem
-//		(3) The database file will not be modified
-func (t *ToDo) GetItem(id int) (ToDoItem, error) {
-
-	// Check if item exists before trying to get it
-	// this is a good practice, return an error if the
-	// item does not exist
-	var item ToDoItem
-	pattern := redisKeyFromId(id)
-	err := t.getItemFromRedis(pattern, &item)
-	if err != nil {
-		return ToDoItem{}, err
-	}
-
-	return item, nil
-}
-
-// ChangeItemDoneStatus accepts an item id and a boolean status.
-// It returns an error if the status could not be updated for any
-// reason.  For example, the item itself does not exist, or an
-// IO error trying to save the updated status.
-
-// Preconditions:   (1) The database file must exist and be a valid
-//
-//					(2) The item must exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
-//	    				exists in the DB, if not, return an error
-//
-// Postconditions:
-//
-//	 (1) The items status in the database will be updated
-//		(2) If there is an error, it will be returned.
-//		(3) This function MUST use existing functionality for most of its
-//			work.  For example, it should call GetItem() to get the item
-//			from the DB, then it should call UpdateItem() to update the
-//			item in the DB (after the status is changed).
-func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
-
-	//update was successful
-	return errors.New("not implemented")
-}
-
-// GetAllItems returns all items from the DB.  If successful it
-// returns a slice of all of the items to the caller
-// Preconditions:   (1) The database file must exist and be a valid
-//
-// Postconditions:
-//
-//	 (1) All items will be returned, if any exist
-//		(2) If there is an error, it will be returned
-//			along with an empty slice
-//		(3) The database file will not be modified
-func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
-
-	//Now that we have the DB loaded, lets crate a slice
-	var toDoList []ToDoItem
-	var toDoItem ToDoItem
-
-	//Lets query redis for all of the items
-	pattern := RedisKeyPrefix + "*"
-	ks, _ := t.cacheClient.Keys(t.context, pattern).Result()
-	for _, key := range ks {
-		err := t.getItemFromRedis(key, &toDoItem)
-		if err != nil {
-			return nil, err
-		}
-		toDoList = append(toDoList, toDoItem)
-	}
-
-	return toDoList, nil
-}
-
-// PrintItem accepts a ToDoItem and prints it to the console
-// in a JSON pretty format. As some help, look at the
-// json.MarshalIndent() function from our in class go tutorial.
-func (t *ToDo) PrintItem(item ToDoItem) {
-	jsonBytes, _ := json.MarshalIndent(item, "", "  ")
-	fmt.Println(string(jsonBytes))
-}
-
-// PrintAllItems accepts a slice of ToDoItems and prints them to the console
-// in a JSON pretty format.  It should call PrintItem() to print each item
-// versus repeating the code.
-func (t *ToDo) PrintAllItems(itemList []ToDoItem) {
-	for _, item := range itemList {
-		t.PrintItem(item)
-	}
-}
-
-// JsonToItem accepts a json string and returns a ToDoItem
-// This is helpful because the CLI accepts todo items for insertion
-// and updates in JSON format.  We need to convert it to a ToDoItem
-// struct to perform any operations on it.
-func (t *ToDo) JsonToItem(jsonString string) (ToDoItem, error) {
-	var item ToDoItem
-	err := json.Unmarshal([]byte(jsonString), &item)
-	if err != nil {
-		return ToDoItem{}, err
-	}
-
-	return item, nil
-}
@@ -0,0 +1,430 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are the formats todos can be imported and exported in.  JSON is
+// an array of todos, NDJSON is one todo per line, and CSV has a header
+// row naming the columns, see csvColumns
+const (
+	FormatJSON   = "json"
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// MaxImportItems bounds how many todos a single import can have
+const MaxImportItems = 10000
+
+// ErrUnknownFormat is returned when asked to import or export a format
+// that is not one of the Format constants
+var ErrUnknownFormat = errors.New("unknown format")
+
+// ErrInvalidImport is returned when the data to import cannot be read
+// at all, for example a JSON import that is not an array, or a CSV
+// import without a title column.  Problems with single todos do not
+// stop an import, they are reported in the ImportResult
+var ErrInvalidImport = errors.New("invalid import")
+
+// csvColumns are the columns of a CSV export, in this order.  A CSV
+// import can have any of them, in any order, only title is required.
+// Tags are kept in a single column, see joinCSVTags, and times are
+// RFC3339, a due date can also be just a date like 2024-05-01
+var csvColumns = []string{
+	"id", "title", "done", "description", "priority", "tags",
+	"dueDate", "createdAt", "updatedAt", "completedAt",
+}
+
+// csvTagSeparator separates the tags in the tags column of a CSV file.
+// A tag can have a ; in it, so in a tag it is escaped with csvTagEscape,
+// and so is the escape itself, the tags a;b and c are written a\;b;c
+const (
+	csvTagSeparator = ';'
+	csvTagEscape    = '\\'
+)
+
+// maxImportLineLength bounds how long a line of an NDJSON import can be
+const maxImportLineLength = 1024 * 1024
+
+// ImportError says why one todo of an import was not imported.  Row is
+// the position of the todo in a JSON array, or the line it is on in a
+// CSV or NDJSON file, counting from 1
+type ImportError struct {
+	Row     int          `json:"row"`
+	Id      int          `json:"id,omitempty"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// ImportResult says what happened to the todos of an import.  Ids are
+// the ids the imported todos were stored under, in the order they were
+// in the import
+type ImportResult struct {
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Ids      []int         `json:"ids"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// importRow is one todo read from an import, or the reason it could
+// not be read
+type importRow struct {
+	row  int
+	item ToDoItem
+	err  error
+}
+
+func newImportResult() ImportResult {
+	return ImportResult{Ids: []int{}, Errors: []ImportError{}}
+}
+
+// succeeded records a todo that was imported
+func (r *ImportResult) succeeded(item ToDoItem) {
+	r.Imported++
+	r.Ids = append(r.Ids, item.Id)
+}
+
+// failed records a todo that was not imported, and why
+func (r *ImportResult) failed(row importRow, err error) {
+	importErr := ImportError{Row: row.row, Id: row.item.Id, Message: err.Error()}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		importErr.Fields = verr.Fields
+	}
+
+	r.Failed++
+	r.Errors = append(r.Errors, importErr)
+}
+
+//------------------------------------------------------------
+// READING IMPORTS
+//------------------------------------------------------------
+
+// readImport reads every todo from r.  A todo that cannot be read gets
+// a row with the error, the other todos are still read.  An error is
+// only returned if the data cannot be read at all
+func readImport(r io.Reader, format string) ([]importRow, error) {
+	var rows []importRow
+	var err error
+	switch format {
+	case FormatJSON:
+		rows, err = readJSONImport(r)
+	case FormatNDJSON:
+		rows, err = readNDJSONImport(r)
+	case FormatCSV:
+		rows, err = readCSVImport(r)
+	default:
+		return nil, fmt.Errorf("%w %q, must be one of %s, %s or %s", ErrUnknownFormat, format,
+			FormatJSON, FormatCSV, FormatNDJSON)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) > MaxImportItems {
+		return nil, fmt.Errorf("%w: an import can have at most %d todos, this one has %d",
+			ErrInvalidImport, MaxImportItems, len(rows))
+	}
+	return rows, nil
+}
+
+// readJSONImport reads a JSON array of todos
+func readJSONImport(r io.Reader) ([]importRow, error) {
+	var elements []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&elements); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("%w: a JSON import must be an array of todos", ErrInvalidImport)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+
+	rows := make([]importRow, 0, len(elements))
+	for i, element := range elements {
+		item, err := DecodeItem(element)
+		rows = append(rows, importRow{row: i + 1, item: item, err: err})
+	}
+	return rows, nil
+}
+
+// readNDJSONImport reads one todo from every line, empty lines are
+// skipped
+func readNDJSONImport(r io.Reader) ([]importRow, error) {
+	var rows []importRow
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineLength)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		item, err := DecodeItem(data)
+		rows = append(rows, importRow{row: line, item: item, err: err})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	return rows, nil
+}
+
+// readCSVImport reads a CSV file with a header row
+func readCSVImport(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+
+	known := make(map[string]bool, len(csvColumns))
+	for _, column := range csvColumns {
+		known[column] = true
+	}
+	hasTitle := false
+	for i, column := range header {
+		column = strings.TrimSpace(column)
+		if !known[column] {
+			return nil, fmt.Errorf("%w: unknown CSV column %q, the columns are %s",
+				ErrInvalidImport, column, strings.Join(csvColumns, ", "))
+		}
+		header[i] = column
+		hasTitle = hasTitle || column == "title"
+	}
+	if !hasTitle {
+		return nil, fmt.Errorf("%w: a CSV import must have a title column", ErrInvalidImport)
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+
+		//A row with the wrong number of cells is a problem with just that
+		//row, anything else means we cannot trust the rest of the file
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rows = append(rows, importRow{row: line, err: fmt.Errorf("%w: expected %d cells, got %d",
+				ErrInvalidItem, len(header), len(record))})
+			continue
+		}
+
+		item, err := itemFromCSV(header, record)
+		rows = append(rows, importRow{row: line, item: item, err: err})
+	}
+}
+
+// itemFromCSV turns a CSV record into a todo, every cell that cannot be
+// read is reported as a field of a *ValidationError
+func itemFromCSV(header []string, record []string) (ToDoItem, error) {
+	var item ToDoItem
+	verr := &ValidationError{}
+	badCell := func(column string, rule string, message string) {
+		verr.Fields = append(verr.Fields, FieldError{Field: column, Rule: rule, Message: message})
+	}
+
+	for i, column := range header {
+		value := strings.TrimSpace(record[i])
+		if value == "" && column != "title" {
+			continue
+		}
+
+		switch column {
+		case "id":
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				badCell(column, "number", fmt.Sprintf("id must be a number, got %q", value))
+			}
+			item.Id = id
+		case "title":
+			item.Title = value
+		case "done":
+			done, err := strconv.ParseBool(value)
+			if err != nil {
+				badCell(column, "bool", fmt.Sprintf("done must be true or false, got %q", value))
+			}
+			item.IsDone = done
+		case "description":
+			item.Description = value
+		case "priority":
+			item.Priority = value
+		case "tags":
+			item.Tags = splitCSVTags(value)
+		case "dueDate", "createdAt", "updatedAt", "completedAt":
+			t, err := parseCSVTime(value)
+			if err != nil {
+				badCell(column, "time", fmt.Sprintf("%s must be an RFC3339 time or a date, got %q", column, value))
+				continue
+			}
+			switch column {
+			case "dueDate":
+				item.DueDate = t
+			case "createdAt":
+				item.CreatedAt = t
+			case "updatedAt":
+				item.UpdatedAt = t
+			case "completedAt":
+				item.CompletedAt = t
+			}
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return item, verr
+	}
+	return item, nil
+}
+
+// splitCSVTags reads the tags column of a CSV file, see joinCSVTags.  A
+// \ that is not followed by a ; or another \ is just a \, so files
+// written before tags were escaped read the same as they always did
+func splitCSVTags(value string) []string {
+	var tags []string
+	var tag strings.Builder
+	endTag := func() {
+		if t := strings.TrimSpace(tag.String()); t != "" {
+			tags = append(tags, t)
+		}
+		tag.Reset()
+	}
+
+	//; and \ are single bytes that are never part of a longer UTF-8
+	//character, so we can go byte by byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == csvTagEscape && i+1 < len(value) &&
+			(value[i+1] == csvTagSeparator || value[i+1] == csvTagEscape):
+			i++
+			tag.WriteByte(value[i])
+		case c == csvTagSeparator:
+			endTag()
+		default:
+			tag.WriteByte(c)
+		}
+	}
+	endTag()
+	return tags
+}
+
+// parseCSVTime reads a time from a CSV cell, either an RFC3339 time or
+// just a date like 2024-05-01
+func parseCSVTime(value string) (*time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time %q", value)
+}
+
+//------------------------------------------------------------
+// WRITING EXPORTS
+//------------------------------------------------------------
+
+// writeExport writes the items to w in the format provided, in id order.
+// No items is an empty JSON array, not null
+func writeExport(w io.Writer, format string, items []ToDoItem) error {
+	if items == nil {
+		items = []ToDoItem{}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Id < items[j].Id
+	})
+
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	case FormatNDJSON:
+		encoder := json.NewEncoder(w)
+		for _, item := range items {
+			if err := encoder.Encode(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		return writeCSVExport(w, items)
+	default:
+		return fmt.Errorf("%w %q, must be one of %s, %s or %s", ErrUnknownFormat, format,
+			FormatJSON, FormatCSV, FormatNDJSON)
+	}
+}
+
+// writeCSVExport writes a header row with csvColumns, and a row for
+// every item
+func writeCSVExport(w io.Writer, items []ToDoItem) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvColumns); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		record := []string{
+			strconv.Itoa(item.Id),
+			item.Title,
+			strconv.FormatBool(item.IsDone),
+			item.Description,
+			item.Priority,
+			joinCSVTags(item.Tags),
+			formatCSVTime(item.DueDate),
+			formatCSVTime(item.CreatedAt),
+			formatCSVTime(item.UpdatedAt),
+			formatCSVTime(item.CompletedAt),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// joinCSVTags writes the tags for the tags column of a CSV file, they
+// are separated by csvTagSeparator, which is escaped in the tags
+func joinCSVTags(tags []string) string {
+	var b strings.Builder
+	for i, tag := range tags {
+		if i > 0 {
+			b.WriteByte(csvTagSeparator)
+		}
+		for j := 0; j < len(tag); j++ {
+			if tag[j] == csvTagSeparator || tag[j] == csvTagEscape {
+				b.WriteByte(csvTagEscape)
+			}
+			b.WriteByte(tag[j])
+		}
+	}
+	return b.String()
+}
+
+// formatCSVTime writes a time for a CSV cell, no time is an empty cell
+func formatCSVTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
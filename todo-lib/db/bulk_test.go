@@ -0,0 +1,65 @@
+package db
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestExportImportRoundTrip exports todos from one store and imports
+// them into an empty one, in every format.  The tags have the CSV tag
+// separator and its escape in them, they must come back as they were
+func TestExportImportRoundTrip(t *testing.T) {
+	items := []ToDoItem{
+		{Id: 1, Title: "learn go", Tags: []string{"school;work", "go"}},
+		{Id: 2, Title: "learn csv", IsDone: true, Tags: []string{`back\slash`, `ends with \`, `\;`}},
+		{Id: 3, Title: "learn nothing"},
+	}
+
+	for _, format := range []string{FormatJSON, FormatCSV, FormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			from := NewWithStore(NewMemoryStore())
+			for _, item := range items {
+				if _, err := from.AddItem(item); err != nil {
+					t.Fatalf("AddItem(%d): %v", item.Id, err)
+				}
+			}
+
+			var export bytes.Buffer
+			if err := from.ExportItems(&export, format); err != nil {
+				t.Fatalf("ExportItems: %v", err)
+			}
+			to := NewWithStore(NewMemoryStore())
+			result, err := to.ImportItems(&export, format)
+			if err != nil {
+				t.Fatalf("ImportItems: %v", err)
+			}
+			if result.Imported != len(items) {
+				t.Fatalf("imported %d todos, want %d: %+v", result.Imported, len(items), result.Errors)
+			}
+
+			for _, want := range items {
+				got, err := to.GetItem(want.Id)
+				if err != nil {
+					t.Fatalf("GetItem(%d): %v", want.Id, err)
+				}
+				if got.Title != want.Title || got.IsDone != want.IsDone || !reflect.DeepEqual(got.Tags, want.Tags) {
+					t.Fatalf("todo %d came back as %q, done %v, tags %q, want %q, done %v, tags %q",
+						want.Id, got.Title, got.IsDone, got.Tags, want.Title, want.IsDone, want.Tags)
+				}
+			}
+		})
+	}
+}
+
+// TestExportEmptyStore checks that exporting no todos as JSON is an
+// empty array, which a client can loop over, and not null
+func TestExportEmptyStore(t *testing.T) {
+	var export bytes.Buffer
+	if err := NewWithStore(NewMemoryStore()).ExportItems(&export, FormatJSON); err != nil {
+		t.Fatalf("ExportItems: %v", err)
+	}
+	if got := export.String(); got != "[]\n" {
+		t.Fatalf("exporting an empty store wrote %q, want []", got)
+	}
+}
@@ -0,0 +1,585 @@
+package db
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// These are the modes a fallbackStore can be in, they are reported by
+// Status, and from there by the /health endpoint
+const (
+	//ModeNormal means every call goes to redis
+	ModeNormal = "normal"
+
+	//ModeDegraded means redis is not reachable, calls are served from
+	//the local copy and writes are queued until redis is back
+	ModeDegraded = "degraded"
+)
+
+// These bound how long the fallbackStore waits between attempts to
+// reconnect to redis, the wait doubles after every failed attempt
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// These are the kinds of writes that are queued while we are degraded
+const (
+	pendingAdd       = "add"
+	pendingUpdate    = "update"
+	pendingDelete    = "delete"
+	pendingDeleteAll = "deleteAll"
+)
+
+// ErrDegraded is returned by NextID while redis is not available.  An
+// id we handed out ourselves could be taken in redis by another API
+// instance by the time redis is back, so while we are degraded we do not
+// hand out ids at all, only items with an id the client picked can be
+// added
+var ErrDegraded = errors.New("new ids cannot be handed out while redis is not available")
+
+// pendingWrite is a write that was made while redis was not reachable,
+// it is replayed against redis once it is back.  The version is only
+// checked against the local copy, the replay does not look at it
+type pendingWrite struct {
+	op      string
+	item    ToDoItem
+	id      int
+	version int
+}
+
+// itemId returns the id of the item the write is for, a deleteAll is
+// not for one item and returns 0
+func (w pendingWrite) itemId() int {
+	if w.op == pendingDelete {
+		return w.id
+	}
+	return w.item.Id
+}
+
+// StoreStatus describes the health of a Store, see ToDo.Status
+type StoreStatus struct {
+	Mode          string     `json:"mode"`
+	Since         *time.Time `json:"since,omitempty"`
+	PendingWrites int        `json:"pendingWrites"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+// fallbackStore keeps the API working when redis goes away.  While redis
+// is up every call goes to it, and the results are also kept in a local
+// memory store.  When a call fails and redis does not answer a PING we
+// switch to degraded mode:
+//
+//  1. Reads are served from the local copy, so clients see the items as
+//     they were when redis went away, plus their own changes.  Items we
+//     never read from redis, and changes made through other API
+//     instances, are missing, the API marks such lists as partial.
+//  2. Writes are made to the local copy and queued.  New ids are not
+//     handed out, see ErrDegraded.
+//  3. In the background we try to reconnect, waiting longer after every
+//     failed attempt.  Once redis answers, the index is rebuilt, the
+//     queued writes are replayed in order, and we switch back.  A
+//     queued write that conflicts with what is in redis by then, like
+//     an add of an id another API instance added, is logged and dropped.
+type fallbackStore struct {
+	primary *redisStore
+	local   *memoryStore
+
+	//mu guards the mode.  Calls hold it for reading, switching modes
+	//holds it for writing, so no call sees a half finished switch
+	mu      sync.RWMutex
+	mode    string
+	since   time.Time
+	lastErr string
+
+	//pendingMu guards the queue of writes, and makes each local write and
+	//its entry in the queue happen in the same order
+	pendingMu sync.Mutex
+	pending   []pendingWrite
+
+	//wake tells the reconnect loop that we just became degraded
+	wake chan struct{}
+}
+
+// newFallbackStore wraps the redis store, and starts the background loop
+// that reconnects to redis when we are degraded
+func newFallbackStore(primary *redisStore) *fallbackStore {
+	f := &fallbackStore{
+		primary: primary,
+		local:   &memoryStore{toDoMap: make(DbMap), nextId: 1},
+		mode:    ModeNormal,
+		since:   time.Now().UTC(),
+		wake:    make(chan struct{}, 1),
+	}
+
+	//If redis is not there when we start, start out degraded
+	if err := primary.Ping(); err != nil {
+		f.degrade(err)
+	}
+
+	go f.reconnectLoop()
+	return f
+}
+
+// Status returns the mode we are in, since when, and how many writes
+// are waiting for redis to come back
+func (f *fallbackStore) Status() StoreStatus {
+	f.mu.RLock()
+	status := StoreStatus{
+		Mode:      f.mode,
+		LastError: f.lastErr,
+	}
+	since := f.since
+	status.Since = &since
+	f.mu.RUnlock()
+
+	f.pendingMu.Lock()
+	status.PendingWrites = len(f.pending)
+	f.pendingMu.Unlock()
+
+	return status
+}
+
+//------------------------------------------------------------
+// STORE IMPLEMENTATION
+//------------------------------------------------------------
+
+func (f *fallbackStore) NextID() (int, error) {
+	var id int
+	ok, err := f.tryPrimary(func() error {
+		var err error
+		id, err = f.primary.NextID()
+		return err
+	})
+	if ok {
+		return id, err
+	}
+
+	//Only redis hands out ids, see ErrDegraded
+	return 0, ErrDegraded
+}
+
+func (f *fallbackStore) AddItem(item ToDoItem) error {
+	return f.write(pendingWrite{op: pendingAdd, item: item}, func() error {
+		return f.primary.AddItem(item)
+	})
+}
+
+func (f *fallbackStore) UpdateItem(item ToDoItem, version int) error {
+	return f.write(pendingWrite{op: pendingUpdate, item: item, version: version}, func() error {
+		return f.primary.UpdateItem(item, version)
+	})
+}
+
+// SetItemDone cannot use write, the item it queues is not known until
+// the status has been changed.  Redis does the change while it is up,
+// while we are degraded the local copy does it, and the item it ends up
+// with is queued as an update
+func (f *fallbackStore) SetItemDone(id int, done bool, now time.Time) (ToDoItem, error) {
+	for {
+		var item ToDoItem
+		ok, err := f.tryPrimary(func() error {
+			var err error
+			item, err = f.primary.SetItemDone(id, done, now)
+			if err == nil {
+				f.mirrorPut(item)
+			}
+			return err
+		})
+		if ok {
+			return item, err
+		}
+
+		f.mu.RLock()
+		if f.mode == ModeNormal {
+			//redis came back while we were getting here, try it again
+			f.mu.RUnlock()
+			continue
+		}
+		item, err = f.setItemDoneLocal(id, done, now)
+		f.mu.RUnlock()
+		return item, err
+	}
+}
+
+func (f *fallbackStore) DeleteItem(id int, version int) error {
+	return f.write(pendingWrite{op: pendingDelete, id: id, version: version}, func() error {
+		return f.primary.DeleteItem(id, version)
+	})
+}
+
+func (f *fallbackStore) DeleteAll() error {
+	return f.write(pendingWrite{op: pendingDeleteAll}, func() error {
+		return f.primary.DeleteAll()
+	})
+}
+
+func (f *fallbackStore) GetItem(id int) (ToDoItem, error) {
+	var item ToDoItem
+	ok, err := f.tryPrimary(func() error {
+		var err error
+		item, err = f.primary.GetItem(id)
+		if err == nil {
+			f.mirrorPut(item)
+		}
+		return err
+	})
+	if ok {
+		return item, err
+	}
+
+	return f.local.GetItem(id)
+}
+
+func (f *fallbackStore) GetAllItems() ([]ToDoItem, error) {
+	var toDoList []ToDoItem
+	ok, err := f.tryPrimary(func() error {
+		var err error
+		toDoList, err = f.primary.GetAllItems()
+		if err == nil {
+			f.mirrorReplaceAll(toDoList)
+		}
+		return err
+	})
+	if ok {
+		return toDoList, err
+	}
+
+	return f.local.GetAllItems()
+}
+
+func (f *fallbackStore) GetItemsPage(cursor string, limit int) ([]ToDoItem, string, error) {
+	var toDoList []ToDoItem
+	var nextCursor string
+	ok, err := f.tryPrimary(func() error {
+		var err error
+		toDoList, nextCursor, err = f.primary.GetItemsPage(cursor, limit)
+		if err == nil {
+			for _, item := range toDoList {
+				f.mirrorPut(item)
+			}
+		}
+		return err
+	})
+	if ok {
+		return toDoList, nextCursor, err
+	}
+
+	//Both stores use the id of the last item as the cursor, so a client
+	//can keep paging when we switch
+	return f.local.GetItemsPage(cursor, limit)
+}
+
+// Ping always succeeds, even while redis is away we can serve requests
+// from the local copy.  Failing here would make Kubernetes take us out
+// of service, which is exactly what degraded mode is there to avoid,
+// Status is how we tell that redis is gone
+func (f *fallbackStore) Ping() error {
+	return f.local.Ping()
+}
+
+//------------------------------------------------------------
+// FALLBACK HELPERS
+//------------------------------------------------------------
+
+// tryPrimary runs fn against redis, unless we are degraded.  It returns
+// false if the caller has to use the local copy instead, because we are
+// degraded, or because redis just went away.  An error from redis while
+// it still answers a PING, like an item that does not exist, is a real
+// error and is returned to the caller
+func (f *fallbackStore) tryPrimary(fn func() error) (bool, error) {
+	f.mu.RLock()
+	if f.mode != ModeNormal {
+		f.mu.RUnlock()
+		return false, nil
+	}
+
+	err := fn()
+	if err == nil || f.primary.Ping() == nil {
+		f.mu.RUnlock()
+		return true, err
+	}
+	f.mu.RUnlock()
+
+	f.degrade(err)
+	return false, nil
+}
+
+// write makes a change in redis, and in the local copy, while redis is
+// up.  While we are degraded it makes the change in the local copy and
+// queues it for later
+func (f *fallbackStore) write(w pendingWrite, fn func() error) error {
+	for {
+		ok, err := f.tryPrimary(func() error {
+			err := fn()
+			if err == nil {
+				f.mirrorWrite(w)
+			}
+			return err
+		})
+		if ok {
+			return err
+		}
+
+		f.mu.RLock()
+		if f.mode == ModeNormal {
+			//redis came back while we were getting here, try it again
+			f.mu.RUnlock()
+			continue
+		}
+		err = f.writeLocal(w)
+		f.mu.RUnlock()
+		return err
+	}
+}
+
+// writeLocal makes a change in the local copy, with the same checks
+// redis would make, and queues it for when redis is back
+func (f *fallbackStore) writeLocal(w pendingWrite) error {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+
+	var err error
+	switch w.op {
+	case pendingAdd:
+		err = f.local.AddItem(w.item)
+	case pendingUpdate:
+		err = f.local.UpdateItem(w.item, w.version)
+	case pendingDelete:
+		err = f.local.DeleteItem(w.id, w.version)
+	case pendingDeleteAll:
+		err = f.local.DeleteAll()
+	}
+	if err != nil {
+		return err
+	}
+
+	f.pending = append(f.pending, w)
+	return nil
+}
+
+// setItemDoneLocal changes the status in the local copy, and queues the
+// changed item for when redis is back
+func (f *fallbackStore) setItemDoneLocal(id int, done bool, now time.Time) (ToDoItem, error) {
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+
+	item, err := f.local.SetItemDone(id, done, now)
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	f.pending = append(f.pending, pendingWrite{op: pendingUpdate, item: item})
+	return item, nil
+}
+
+// degrade switches to degraded mode, and wakes up the reconnect loop
+func (f *fallbackStore) degrade(cause error) {
+	f.mu.Lock()
+	if f.mode == ModeNormal {
+		log.Println("Redis is not available, switching to degraded mode: " + cause.Error())
+		f.mode = ModeDegraded
+		f.since = time.Now().UTC()
+	}
+	f.lastErr = cause.Error()
+	f.mu.Unlock()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reconnectLoop runs for as long as the API does.  Whenever we are
+// degraded it tries to get back to redis, waiting a little longer after
+// every failed attempt
+func (f *fallbackStore) reconnectLoop() {
+	backoff := minReconnectBackoff
+	for {
+		f.mu.RLock()
+		degraded := f.mode == ModeDegraded
+		f.mu.RUnlock()
+		if !degraded {
+			backoff = minReconnectBackoff
+			<-f.wake
+			continue
+		}
+
+		time.Sleep(backoff)
+		if err := f.reconcile(); err != nil {
+			f.mu.Lock()
+			f.lastErr = err.Error()
+			f.mu.Unlock()
+
+			backoff *= 2
+			if backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+			continue
+		}
+	}
+}
+
+// reconcile checks if redis is back.  If it is, it rebuilds the index,
+// replays the queued writes in order and switches back to normal mode.
+// If redis goes away again part way through, the writes that were not
+// replayed stay in the queue for the next attempt.
+//
+// The index is rebuilt and the queue is replayed without holding the
+// mode lock, so calls are still served from the local copy while that
+// takes its time.  Only the reconnect loop calls reconcile, and while we
+// are degraded no call goes to redis, so nothing gets in its way
+func (f *fallbackStore) reconcile() error {
+	if err := f.primary.Ping(); err != nil {
+		return err
+	}
+
+	//redis may have been restarted and loaded with items, so the index
+	//and the id counter need to catch up, just like at startup
+	if err := f.primary.rebuildIndex(); err != nil {
+		return err
+	}
+
+	//New writes are queued behind the ones we replay, we only ever take
+	//writes off the front of the queue
+	dropped := make(map[int]bool)
+	for {
+		f.pendingMu.Lock()
+		if len(f.pending) == 0 {
+			f.pendingMu.Unlock()
+			break
+		}
+		w := f.pending[0]
+		f.pendingMu.Unlock()
+
+		if err := f.replayOrDrop(w, dropped); err != nil {
+			return err
+		}
+
+		f.pendingMu.Lock()
+		f.pending = f.pending[1:]
+		f.pendingMu.Unlock()
+	}
+
+	//A write can have been queued since we last looked.  Hold the mode
+	//lock while we replay those few and switch back, so nothing is
+	//queued once we are in normal mode
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pendingMu.Lock()
+	defer f.pendingMu.Unlock()
+
+	for len(f.pending) > 0 {
+		if err := f.replayOrDrop(f.pending[0], dropped); err != nil {
+			return err
+		}
+		f.pending = f.pending[1:]
+	}
+
+	log.Println("Redis is available again, switching back to normal mode")
+	f.mode = ModeNormal
+	f.since = time.Now().UTC()
+	f.lastErr = ""
+	return nil
+}
+
+// replayOrDrop replays one queued write.  It only returns an error if
+// redis went away again, then the write has to stay in the queue.  If
+// redis is still there the write itself is the problem, trying it again
+// will not help, so it is logged and dropped.  The later writes to the
+// same item build on the one we dropped, dropped keeps track of their
+// ids so they are dropped too
+func (f *fallbackStore) replayOrDrop(w pendingWrite, dropped map[int]bool) error {
+	if w.op == pendingDeleteAll {
+		for id := range dropped {
+			delete(dropped, id)
+		}
+	} else if dropped[w.itemId()] {
+		log.Printf("Dropping a queued %s of item %d, an earlier write to it was dropped", w.op, w.itemId())
+		return nil
+	}
+
+	err := f.replay(w)
+	if err == nil {
+		return nil
+	}
+	if f.primary.Ping() != nil {
+		return err
+	}
+
+	if errors.Is(err, ErrItemExists) || errors.Is(err, ErrNotFound) {
+		log.Printf("Dropping a queued %s of item %d, it conflicts with redis: %v", w.op, w.itemId(), err)
+	} else {
+		log.Printf("Dropping a %s that could not be replayed: %v", w.op, err)
+	}
+	if w.op != pendingDeleteAll {
+		dropped[w.itemId()] = true
+	}
+	return nil
+}
+
+// replay makes one queued write in redis.  Versions are not checked,
+// they were checked against the local copy when the write was made.
+// An add of an item that is in redis, or an update of an item that is
+// not, means the item was changed in redis while we were degraded, by
+// another API instance or a restart.  We do not overwrite that change,
+// the write fails with ErrItemExists or ErrNotFound.  A delete of an
+// item that is not in redis is done already
+func (f *fallbackStore) replay(w pendingWrite) error {
+	var err error
+	switch w.op {
+	case pendingAdd:
+		err = f.primary.AddItem(w.item)
+	case pendingUpdate:
+		err = f.primary.UpdateItem(w.item, AnyVersion)
+	case pendingDelete:
+		err = f.primary.DeleteItem(w.id, AnyVersion)
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+	case pendingDeleteAll:
+		err = f.primary.DeleteAll()
+	}
+	return err
+}
+
+// mirrorWrite applies a write that redis accepted to the local copy
+func (f *fallbackStore) mirrorWrite(w pendingWrite) {
+	switch w.op {
+	case pendingAdd, pendingUpdate:
+		f.mirrorPut(w.item)
+	case pendingDelete:
+		f.local.DeleteItem(w.id, AnyVersion)
+	case pendingDeleteAll:
+		f.local.DeleteAll()
+	}
+}
+
+// mirrorPut stores an item we got from redis in the local copy, and
+// keeps the local id counter past it
+func (f *fallbackStore) mirrorPut(item ToDoItem) {
+	f.local.mu.Lock()
+	defer f.local.mu.Unlock()
+
+	f.local.toDoMap[item.Id] = item
+	if item.Id >= f.local.nextId {
+		f.local.nextId = item.Id + 1
+	}
+}
+
+// mirrorReplaceAll makes the local copy match the full list of items
+// we got from redis
+func (f *fallbackStore) mirrorReplaceAll(items []ToDoItem) {
+	f.local.mu.Lock()
+	defer f.local.mu.Unlock()
+
+	f.local.toDoMap = make(DbMap, len(items))
+	for _, item := range items {
+		f.local.toDoMap[item.Id] = item
+		if item.Id >= f.local.nextId {
+			f.local.nextId = item.Id + 1
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// TestFallbackStoreDegraded stops redis under the fallback store.  While
+// redis is gone adds that need a new id must fail with ErrDegraded, an
+// add with an id the client picked is made locally, and once redis is
+// back that add must be in redis under the same id
+func TestFallbackStoreDegraded(t *testing.T) {
+	m := miniredis.RunT(t)
+	registerJSONStub(t, m)
+	r, err := newRedisStore(m.Addr())
+	if err != nil {
+		t.Fatalf("newRedisStore: %v", err)
+	}
+	t.Cleanup(func() { r.cacheClient.Close() })
+	f := newFallbackStore(r)
+	todo := NewWithStore(f)
+
+	first, err := todo.AddItem(ToDoItem{Title: "learn go"})
+	if err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	m.Close()
+	if _, err := todo.AddItem(ToDoItem{Title: "learn redis"}); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("AddItem without an id while redis is gone: got %v, want ErrDegraded", err)
+	}
+	if status := f.Status(); status.Mode != ModeDegraded {
+		t.Fatalf("the store is in %s mode, want %s", status.Mode, ModeDegraded)
+	}
+	picked, err := todo.AddItem(ToDoItem{Id: 10, Title: "learn docker"})
+	if err != nil {
+		t.Fatalf("AddItem with an id while redis is gone: %v", err)
+	}
+	items, err := todo.GetAllItems()
+	if err != nil {
+		t.Fatalf("GetAllItems while redis is gone: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("GetAllItems while redis is gone returned %d items, want 2", len(items))
+	}
+
+	//A new miniredis server does not have the JSON commands yet
+	if err := m.Restart(); err != nil {
+		t.Fatalf("restarting miniredis: %v", err)
+	}
+	registerJSONStub(t, m)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for f.Status().Mode != ModeNormal {
+		if time.Now().After(deadline) {
+			t.Fatalf("the store did not switch back to normal mode, %+v", f.Status())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for _, want := range []ToDoItem{first, picked} {
+		got, err := r.GetItem(want.Id)
+		if err != nil {
+			t.Fatalf("GetItem(%d) from redis: %v", want.Id, err)
+		}
+		if got.Title != want.Title {
+			t.Fatalf("item %d in redis has title %q, want %q", want.Id, got.Title, want.Title)
+		}
+	}
+	if status := f.Status(); status.PendingWrites != 0 {
+		t.Fatalf("%d writes are still queued", status.PendingWrites)
+	}
+}
+
+// TestFallbackStoreReplayConflicts queues writes while redis is gone
+// that conflict with what is in redis when it comes back.  They must be
+// dropped, along with the writes to the same items after them, and the
+// other writes must still be replayed.  The store is made without the
+// reconnect loop, so the test decides when to reconcile
+func TestFallbackStoreReplayConflicts(t *testing.T) {
+	m := miniredis.RunT(t)
+	registerJSONStub(t, m)
+	r, err := newRedisStore(m.Addr())
+	if err != nil {
+		t.Fatalf("newRedisStore: %v", err)
+	}
+	t.Cleanup(func() { r.cacheClient.Close() })
+	f := &fallbackStore{
+		primary: r,
+		local:   &memoryStore{toDoMap: make(DbMap), nextId: 1},
+		mode:    ModeNormal,
+		wake:    make(chan struct{}, 1),
+	}
+	todo := NewWithStore(f)
+
+	learnGo, err := todo.AddItem(ToDoItem{Title: "learn go"})
+	if err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	m.Close()
+	if _, err := todo.AddItem(ToDoItem{Id: 10, Title: "ours"}); err != nil {
+		t.Fatalf("AddItem(10) while redis is gone: %v", err)
+	}
+	if f.Status().Mode != ModeDegraded {
+		t.Fatalf("the store is in %s mode, want %s", f.Status().Mode, ModeDegraded)
+	}
+	if _, err := todo.UpdateItem(ToDoItem{Id: 10, Title: "ours again"}, AnyVersion); err != nil {
+		t.Fatalf("UpdateItem(10) while redis is gone: %v", err)
+	}
+	if _, err := todo.UpdateItem(ToDoItem{Id: learnGo.Id, Title: "learn go well"}, AnyVersion); err != nil {
+		t.Fatalf("UpdateItem(%d) while redis is gone: %v", learnGo.Id, err)
+	}
+	if _, err := todo.AddItem(ToDoItem{Id: 20, Title: "no conflict"}); err != nil {
+		t.Fatalf("AddItem(20) while redis is gone: %v", err)
+	}
+
+	//While we were gone another API instance deleted the item we
+	//updated, and added an item with the id we added
+	if err := m.Restart(); err != nil {
+		t.Fatalf("restarting miniredis: %v", err)
+	}
+	registerJSONStub(t, m)
+	if err := r.DeleteItem(learnGo.Id, AnyVersion); err != nil {
+		t.Fatalf("deleting item %d from redis: %v", learnGo.Id, err)
+	}
+	theirs := ToDoItem{Id: 10, Title: "theirs", Version: 1}
+	if err := r.AddItem(theirs); err != nil {
+		t.Fatalf("adding item 10 to redis: %v", err)
+	}
+
+	if err := f.reconcile(); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if status := f.Status(); status.Mode != ModeNormal || status.PendingWrites != 0 {
+		t.Fatalf("after reconcile the store is %+v, want normal with nothing queued", status)
+	}
+
+	if got, err := r.GetItem(10); err != nil || got.Title != theirs.Title {
+		t.Errorf("item 10 in redis is %+v, %v, want the one the other instance added", got, err)
+	}
+	if _, err := r.GetItem(learnGo.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the update of item %d was replayed as an add, GetItem returned %v", learnGo.Id, err)
+	}
+	if got, err := r.GetItem(20); err != nil || got.Title != "no conflict" {
+		t.Errorf("item 20 in redis is %+v, %v, want the add that did not conflict", got, err)
+	}
+}
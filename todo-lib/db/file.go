@@ -0,0 +1,291 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileStore is a Store that keeps the items in a JSON file, using
+// the same format as the todo CLI.  Every operation loads the file,
+// and every modification saves it back out.  mu makes each load,
+// modify and save run as one step, otherwise two concurrent requests
+// could both load the file and one of the saves would be lost
+type fileStore struct {
+	mu         sync.Mutex
+	dbFileName string
+}
+
+// dbFile is the layout of the JSON file.  Besides the items it keeps
+// the next id to hand out, so ids of deleted items are never reused.
+// Files from before we kept the counter are a plain json array of
+// items, loadDB still accepts those
+type dbFile struct {
+	NextId int        `json:"nextId"`
+	Items  []ToDoItem `json:"items"`
+}
+
+// NewFileStore returns a Store that is backed by the provided JSON
+// file.  If the file doesn't exist, it will be created with an empty
+// list of items.
+func NewFileStore(dbFileName string) (Store, error) {
+
+	//Check if the database file exists, if not use initDB to create it
+	if _, err := os.Stat(dbFileName); err != nil {
+		if err := initDB(dbFileName); err != nil {
+			return nil, err
+		}
+	}
+
+	return &fileStore{
+		dbFileName: dbFileName,
+	}, nil
+}
+
+func (f *fileStore) NextID() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	toDoMap, nextId, err := f.loadDB()
+	if err != nil {
+		return 0, err
+	}
+
+	//Save the bumped counter right away, so the id is not handed
+	//out again even if the item never gets added
+	if err := f.saveDB(toDoMap, nextId+1); err != nil {
+		return 0, err
+	}
+	return nextId, nil
+}
+
+func (f *fileStore) AddItem(item ToDoItem) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	toDoMap, nextId, err := f.loadDB()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := toDoMap[item.Id]; ok {
+		return ErrItemExists
+	}
+
+	toDoMap[item.Id] = item
+	if item.Id >= nextId {
+		nextId = item.Id + 1
+	}
+	return f.saveDB(toDoMap, nextId)
+}
+
+func (f *fileStore) DeleteItem(id int, version int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	toDoMap, nextId, err := f.loadDB()
+	if err != nil {
+		return err
+	}
+
+	existing, ok := toDoMap[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return err
+	}
+
+	delete(toDoMap, id)
+	return f.saveDB(toDoMap, nextId)
+}
+
+func (f *fileStore) DeleteAll() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, nextId, err := f.loadDB()
+	if err != nil {
+		return err
+	}
+
+	return f.saveDB(make(DbMap), nextId)
+}
+
+func (f *fileStore) UpdateItem(item ToDoItem, version int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	toDoMap, nextId, err := f.loadDB()
+	if err != nil {
+		return err
+	}
+
+	existing, ok := toDoMap[item.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return err
+	}
+
+	toDoMap[item.Id] = item
+	return f.saveDB(toDoMap, nextId)
+}
+
+func (f *fileStore) SetItemDone(id int, done bool, now time.Time) (ToDoItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	toDoMap, nextId, err := f.loadDB()
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	item, ok := toDoMap[id]
+	if !ok {
+		return ToDoItem{}, ErrNotFound
+	}
+
+	existing := item
+	item.IsDone = done
+	stampUpdatedItem(&item, existing, now)
+	toDoMap[id] = item
+	if err := f.saveDB(toDoMap, nextId); err != nil {
+		return ToDoItem{}, err
+	}
+	return item, nil
+}
+
+func (f *fileStore) GetItem(id int) (ToDoItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	toDoMap, _, err := f.loadDB()
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	item, ok := toDoMap[id]
+	if !ok {
+		return ToDoItem{}, ErrNotFound
+	}
+
+	return item, nil
+}
+
+func (f *fileStore) GetAllItems() ([]ToDoItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	toDoMap, _, err := f.loadDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var toDoList []ToDoItem
+	for _, item := range toDoMap {
+		toDoList = append(toDoList, item)
+	}
+
+	return toDoList, nil
+}
+
+func (f *fileStore) GetItemsPage(cursor string, limit int) ([]ToDoItem, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	toDoMap, _, err := f.loadDB()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return pageFromMap(toDoMap, cursor, limit)
+}
+
+// Ping checks that the database file can still be read and parsed, and
+// that we are allowed to write to it
+func (f *fileStore) Ping() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, _, err := f.loadDB(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.dbFileName, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+//------------------------------------------------------------
+// FILE HELPERS
+//------------------------------------------------------------
+
+// initDB is a helper function that creates a new, empty, database
+// file.  This is used to make sure that the DB file exists before any
+// operations are performed on it
+func initDB(dbFileName string) error {
+	data, err := json.MarshalIndent(dbFile{NextId: 1, Items: []ToDoItem{}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dbFileName, data, 0644)
+}
+
+func (f *fileStore) saveDB(toDoMap DbMap, nextId int) error {
+	//1. Convert our map into a slice
+	toDoList := make([]ToDoItem, 0, len(toDoMap))
+	for _, item := range toDoMap {
+		toDoList = append(toDoList, item)
+	}
+
+	//2. Marshal the slice and the id counter into json, lets pretty
+	//   print it, but this is not required
+	data, err := json.MarshalIndent(dbFile{NextId: nextId, Items: toDoList}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	//3. Write the json to our file
+	return os.WriteFile(f.dbFileName, data, 0644)
+}
+
+func (f *fileStore) loadDB() (DbMap, int, error) {
+	data, err := os.ReadFile(f.dbFileName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	//Now let's unmarshal the data, older files are just a json array
+	//of items without the id counter
+	var contents dbFile
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &contents.Items)
+	} else {
+		err = json.Unmarshal(data, &contents)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	//Now let's iterate over our slice and add each item to our map,
+	//making sure the counter is past every id we already have
+	nextId := contents.NextId
+	if nextId < 1 {
+		nextId = 1
+	}
+	toDoMap := make(DbMap, len(contents.Items))
+	for _, item := range contents.Items {
+		toDoMap[item.Id] = item
+		if item.Id >= nextId {
+			nextId = item.Id + 1
+		}
+	}
+
+	return toDoMap, nextId, nil
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DbMap is a type alias for a map of ToDoItems.  The key
+// will be the ToDoItem.Id and the value will be the ToDoItem
+type DbMap map[int]ToDoItem
+
+// memoryStore is a Store that keeps all of the items in an in
+// memory map.  Nothing is persisted, so everything is lost when
+// the API is restarted, but it is handy for demos and testing.
+//
+// Gin runs every request in its own goroutine, so all access to the
+// map goes through mu.  Readers take the read lock, so any number of
+// GETs can run together, while anything that changes the map (or the
+// id counter) takes the write lock
+type memoryStore struct {
+	mu      sync.RWMutex
+	toDoMap DbMap
+	nextId  int
+}
+
+// NewMemoryStore returns a new, empty, in memory Store
+func NewMemoryStore() Store {
+	return &memoryStore{
+		toDoMap: make(DbMap),
+		nextId:  1,
+	}
+}
+
+func (m *memoryStore) NextID() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextId
+	m.nextId++
+	return id, nil
+}
+
+func (m *memoryStore) AddItem(item ToDoItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//Before we add an item to the DB, lets make sure
+	//it does not exist, if it does, return an error
+	_, ok := m.toDoMap[item.Id]
+	if ok {
+		return ErrItemExists
+	}
+
+	//Now that we know the item doesn't exist, lets add it to our map,
+	//and make sure NextID will not hand out its id later
+	m.toDoMap[item.Id] = item
+	if item.Id >= m.nextId {
+		m.nextId = item.Id + 1
+	}
+
+	//If everything is ok, return nil for the error
+	return nil
+}
+
+func (m *memoryStore) DeleteItem(id int, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check if item exists before trying to delete it
+	// this is a good practice, return an error if the
+	// item does not exist
+	existing, ok := m.toDoMap[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return err
+	}
+
+	//Now lets use the built-in go delete() function to remove
+	//the item from our map
+	delete(m.toDoMap, id)
+
+	return nil
+}
+
+func (m *memoryStore) DeleteAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//To delete everything, we can just create a new map
+	//and assign it to our existing map.  The garbage collector
+	//will clean up the old map for us
+	m.toDoMap = make(DbMap)
+
+	return nil
+}
+
+func (m *memoryStore) UpdateItem(item ToDoItem, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check if item exists before trying to update it
+	// this is a good practice, return an error if the
+	// item does not exist
+	existing, ok := m.toDoMap[item.Id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return err
+	}
+
+	//Now that we know the item exists, lets update it
+	m.toDoMap[item.Id] = item
+
+	return nil
+}
+
+func (m *memoryStore) SetItemDone(id int, done bool, now time.Time) (ToDoItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.toDoMap[id]
+	if !ok {
+		return ToDoItem{}, ErrNotFound
+	}
+
+	//Holding the lock while we change the item means no other update
+	//can slip in between reading it and writing it back
+	existing := item
+	item.IsDone = done
+	stampUpdatedItem(&item, existing, now)
+	m.toDoMap[id] = item
+
+	return item, nil
+}
+
+func (m *memoryStore) GetItem(id int) (ToDoItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Check if item exists before trying to get it
+	// this is a good practice, return an error if the
+	// item does not exist
+	item, ok := m.toDoMap[id]
+	if !ok {
+		return ToDoItem{}, ErrNotFound
+	}
+
+	return item, nil
+}
+
+func (m *memoryStore) GetAllItems() ([]ToDoItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	//Now that we have the DB loaded, lets crate a slice.  The slice
+	//is a copy, so the caller can use it after we release the lock
+	var toDoList []ToDoItem
+
+	//Now lets iterate over our map and add each item to our slice
+	for _, item := range m.toDoMap {
+		toDoList = append(toDoList, item)
+	}
+
+	//Now that we have all of our items in a slice, return it
+	return toDoList, nil
+}
+
+func (m *memoryStore) GetItemsPage(cursor string, limit int) ([]ToDoItem, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return pageFromMap(m.toDoMap, cursor, limit)
+}
+
+// Ping always succeeds, the map is right here in memory
+func (m *memoryStore) Ping() error {
+	return nil
+}
+
+// pageFromMap returns up to limit items from the map in id order,
+// starting after the id in the cursor.  The map based stores use the id
+// of the last item on a page as the next cursor, so paging stays stable
+// even when items are added or deleted between pages
+func pageFromMap(toDoMap DbMap, cursor string, limit int) ([]ToDoItem, string, error) {
+	after := 0
+	if cursor != "" {
+		id, err := strconv.Atoi(cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		after = id
+	}
+
+	//Go maps have no order, so we sort the ids we still need to return
+	ids := make([]int, 0, len(toDoMap))
+	for id := range toDoMap {
+		if cursor == "" || id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	nextCursor := ""
+	if len(ids) > limit {
+		ids = ids[:limit]
+		nextCursor = strconv.Itoa(ids[limit-1])
+	}
+
+	toDoList := make([]ToDoItem, 0, len(ids))
+	for _, id := range ids {
+		toDoList = append(toDoList, toDoMap[id])
+	}
+	return toDoList, nextCursor, nil
+}
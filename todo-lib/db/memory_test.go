@@ -0,0 +1,171 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// These tests hammer the memory store from many goroutines at once, run
+// them with the race detector, go test -race ./..., so a missing lock
+// shows up as a failure and not just as a wrong count now and then
+
+const (
+	testWorkers        = 8
+	testItemsPerWorker = 100
+)
+
+// TestMemoryStoreConcurrentAccess has every worker add its own items,
+// update them, delete every other one, and list all of the items while
+// the other workers are doing the same.  In the end exactly the items
+// that were not deleted must be left, with their updated titles
+func TestMemoryStoreConcurrentAccess(t *testing.T) {
+	store := NewMemoryStore()
+
+	var wg sync.WaitGroup
+	for w := 0; w < testWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 1; i <= testItemsPerWorker; i++ {
+				id := w*testItemsPerWorker + i
+				if err := store.AddItem(ToDoItem{Id: id, Title: "new", Version: 1}); err != nil {
+					t.Errorf("AddItem(%d): %v", id, err)
+					return
+				}
+				updated := ToDoItem{Id: id, Title: fmt.Sprintf("updated %d", id), Version: 2}
+				if err := store.UpdateItem(updated, 1); err != nil {
+					t.Errorf("UpdateItem(%d): %v", id, err)
+					return
+				}
+				if id%2 == 1 {
+					if err := store.DeleteItem(id, 2); err != nil {
+						t.Errorf("DeleteItem(%d): %v", id, err)
+						return
+					}
+				}
+
+				items, err := store.GetAllItems()
+				if err != nil {
+					t.Errorf("GetAllItems: %v", err)
+					return
+				}
+				if len(items) > testWorkers*testItemsPerWorker {
+					t.Errorf("GetAllItems returned %d items, there are never more than %d",
+						len(items), testWorkers*testItemsPerWorker)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	items, err := store.GetAllItems()
+	if err != nil {
+		t.Fatalf("GetAllItems: %v", err)
+	}
+	if want := testWorkers * testItemsPerWorker / 2; len(items) != want {
+		t.Fatalf("got %d items, want %d", len(items), want)
+	}
+	for _, item := range items {
+		if item.Id%2 == 1 {
+			t.Errorf("item %d was deleted, but it is still there", item.Id)
+		}
+		if want := fmt.Sprintf("updated %d", item.Id); item.Title != want || item.Version != 2 {
+			t.Errorf("item %d has title %q at version %d, want %q at version 2",
+				item.Id, item.Title, item.Version, want)
+		}
+	}
+}
+
+// TestToDoConcurrentAddsGetUniqueIds adds items without an id from
+// many goroutines, every one of them must get an id of its own
+func TestToDoConcurrentAddsGetUniqueIds(t *testing.T) {
+	todo := NewWithStore(NewMemoryStore())
+
+	var wg sync.WaitGroup
+	ids := make(chan int, testWorkers*testItemsPerWorker)
+	for w := 0; w < testWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < testItemsPerWorker; i++ {
+				item, err := todo.AddItem(ToDoItem{Title: "learn go"})
+				if err != nil {
+					t.Errorf("AddItem: %v", err)
+					return
+				}
+				ids <- item.Id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("id %d was handed out twice", id)
+		}
+		seen[id] = true
+	}
+
+	items, err := todo.GetAllItems()
+	if err != nil {
+		t.Fatalf("GetAllItems: %v", err)
+	}
+	if want := testWorkers * testItemsPerWorker; len(items) != want || len(seen) != want {
+		t.Fatalf("got %d items and %d ids, want %d", len(items), len(seen), want)
+	}
+}
+
+// TestToDoConcurrentUpdatesOfOneItem has every worker update the same
+// item at the version it last read.  Updates that lose the race get
+// ErrVersionMismatch, and every update that wins bumps the version by
+// exactly one, so no update is lost without its caller knowing
+func TestToDoConcurrentUpdatesOfOneItem(t *testing.T) {
+	todo := NewWithStore(NewMemoryStore())
+	item, err := todo.AddItem(ToDoItem{Title: "learn go"})
+	if err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for w := 0; w < testWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < testItemsPerWorker; i++ {
+				current, err := todo.GetItem(item.Id)
+				if err != nil {
+					t.Errorf("GetItem: %v", err)
+					return
+				}
+				current.Title = fmt.Sprintf("worker %d update %d", w, i)
+				_, err = todo.UpdateItem(current, current.Version)
+				switch {
+				case errors.Is(err, ErrVersionMismatch):
+				case err != nil:
+					t.Errorf("UpdateItem: %v", err)
+					return
+				default:
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	final, err := todo.GetItem(item.Id)
+	if err != nil {
+		t.Fatalf("GetItem: %v", err)
+	}
+	if final.Version != 1+succeeded {
+		t.Fatalf("item is at version %d after %d updates, want %d", final.Version, succeeded, 1+succeeded)
+	}
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidPatch is returned by PatchItem when the patch is not a JSON
+// object, or would give a field a value of the wrong type
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// mergePatch applies a JSON Merge Patch, see RFC 7396, to the JSON
+// document in doc.  In a merge patch every member replaces the member
+// of the same name in the document, objects are merged the same way all
+// the way down, and a member that is null removes the member from the
+// document.  For example, applying
+//
+//	{"title": "Learn Go", "dueDate": null}
+//
+// changes the title, removes the due date, and leaves everything else
+// alone.  We only accept patches that are JSON objects, a patch that is
+// anything else would replace the whole item
+func mergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	var patchValue any
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	if _, ok := patchValue.(map[string]any); !ok {
+		return nil, fmt.Errorf("%w: a merge patch must be a JSON object", ErrInvalidPatch)
+	}
+
+	return json.Marshal(applyMergePatch(target, patchValue))
+}
+
+// applyMergePatch is the MergePatch function from RFC 7396, it works on
+// the values encoding/json decodes into an any
+func applyMergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = applyMergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
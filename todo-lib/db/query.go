@@ -0,0 +1,298 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery is returned by ParseQuery when one of the query
+// parameters has a value we do not understand
+var ErrInvalidQuery = errors.New("invalid query")
+
+// These are the fields the results of a query can be sorted by, the
+// names match the JSON names of the ToDoItem fields
+const (
+	SortById        = "id"
+	SortByTitle     = "title"
+	SortByPriority  = "priority"
+	SortByDueDate   = "dueDate"
+	SortByCreatedAt = "createdAt"
+	SortByUpdatedAt = "updatedAt"
+)
+
+// These are the orders the results of a query can be sorted in
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Query describes which items to return from QueryItems, and in which
+// order.  Every filter that is set must match for an item to be
+// returned, an empty Query returns every item sorted by id.
+type Query struct {
+	//Done only returns items with this done status
+	Done *bool
+
+	//Title only returns items whose title contains this string,
+	//ignoring case
+	Title string
+
+	//Tags only returns items that have every one of these tags
+	Tags []string
+
+	//Priorities only returns items that have any one of these priorities
+	Priorities []string
+
+	//DueBefore and DueAfter only return items with a due date before
+	//or after these times.  Items without a due date never match
+	DueBefore *time.Time
+	DueAfter  *time.Time
+
+	//Sort is one of the SortBy constants, and Order is OrderAsc or
+	//OrderDesc.  Items that do not have the field we sort by always go
+	//last, and ties are broken by id
+	Sort  string
+	Order string
+}
+
+// ParseQuery builds a Query out of URL query parameters, so every API
+// that uses it understands the same parameters:
+//
+//	done=true|false             done status
+//	title=<text>                title contains text, ignoring case
+//	tag=<tag>                   has the tag, repeat for more tags
+//	priority=low|medium|high    has the priority, repeat for any of several
+//	dueBefore=<date>            due before the date
+//	dueAfter=<date>             due after the date
+//	sort=<field>                id, title, priority, dueDate, createdAt or updatedAt
+//	order=asc|desc              sort order, asc by default
+//
+// Dates are either just a date like 2024-05-01 or a full RFC3339 time.
+// Parameters that are not listed above are ignored.
+func ParseQuery(values url.Values) (Query, error) {
+	var q Query
+
+	if doneS := values.Get("done"); doneS != "" {
+		done, err := strconv.ParseBool(doneS)
+		if err != nil {
+			return Query{}, fmt.Errorf("%w: done must be true or false, got %q", ErrInvalidQuery, doneS)
+		}
+		q.Done = &done
+	}
+
+	q.Title = values.Get("title")
+
+	for _, tag := range values["tag"] {
+		if tag != "" {
+			q.Tags = append(q.Tags, tag)
+		}
+	}
+
+	for _, priority := range values["priority"] {
+		if priorityRank(priority) == 0 {
+			return Query{}, fmt.Errorf("%w: priority must be %s, %s or %s, got %q",
+				ErrInvalidQuery, PriorityLow, PriorityMedium, PriorityHigh, priority)
+		}
+		q.Priorities = append(q.Priorities, priority)
+	}
+
+	var err error
+	if q.DueBefore, err = parseQueryTime(values, "dueBefore"); err != nil {
+		return Query{}, err
+	}
+	if q.DueAfter, err = parseQueryTime(values, "dueAfter"); err != nil {
+		return Query{}, err
+	}
+
+	q.Sort = values.Get("sort")
+	switch q.Sort {
+	case "", SortById, SortByTitle, SortByPriority, SortByDueDate, SortByCreatedAt, SortByUpdatedAt:
+	default:
+		return Query{}, fmt.Errorf("%w: cannot sort by %q", ErrInvalidQuery, q.Sort)
+	}
+
+	q.Order = values.Get("order")
+	switch q.Order {
+	case "", OrderAsc, OrderDesc:
+	default:
+		return Query{}, fmt.Errorf("%w: order must be %s or %s, got %q", ErrInvalidQuery, OrderAsc, OrderDesc, q.Order)
+	}
+
+	return q, nil
+}
+
+// Matches returns true if the item passes every filter of the query
+func (q Query) Matches(item ToDoItem) bool {
+	if q.Done != nil && item.IsDone != *q.Done {
+		return false
+	}
+
+	if q.Title != "" && !strings.Contains(strings.ToLower(item.Title), strings.ToLower(q.Title)) {
+		return false
+	}
+
+	for _, tag := range q.Tags {
+		if !hasTag(item, tag) {
+			return false
+		}
+	}
+
+	if len(q.Priorities) > 0 {
+		found := false
+		for _, priority := range q.Priorities {
+			if item.Priority == priority {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if q.DueBefore != nil && (item.DueDate == nil || !item.DueDate.Before(*q.DueBefore)) {
+		return false
+	}
+	if q.DueAfter != nil && (item.DueDate == nil || !item.DueDate.After(*q.DueAfter)) {
+		return false
+	}
+
+	return true
+}
+
+// QueryItems returns the items that match the query, sorted the way the
+// query asks for.  It works on top of GetAllItems, so every store
+// gives the same answers.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The matching items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty slice
+//		(3) The database file will not be modified
+func (t *ToDo) QueryItems(q Query) ([]ToDoItem, error) {
+	todoList, err := t.GetAllItems()
+	if err != nil {
+		return nil, err
+	}
+
+	var filteredList []ToDoItem
+	for _, item := range todoList {
+		if q.Matches(item) {
+			filteredList = append(filteredList, item)
+		}
+	}
+
+	q.sortItems(filteredList)
+	return filteredList, nil
+}
+
+//------------------------------------------------------------
+// QUERY HELPERS
+//------------------------------------------------------------
+
+// sortItems sorts the items in place by the field and order of the query
+func (q Query) sortItems(items []ToDoItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		c := q.compare(items[i], items[j])
+		if c == 0 {
+			return items[i].Id < items[j].Id
+		}
+		return c < 0
+	})
+}
+
+// compare returns a negative number if a goes before b, a positive
+// number if it goes after b, and 0 if it does not matter
+func (q Query) compare(a, b ToDoItem) int {
+	var c int
+	switch q.Sort {
+	case SortByTitle:
+		c = strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	case SortByPriority:
+		//Items without a priority have rank 0, and should go last
+		ra, rb := priorityRank(a.Priority), priorityRank(b.Priority)
+		if ra == 0 || rb == 0 {
+			return rb - ra
+		}
+		c = ra - rb
+	case SortByDueDate:
+		return q.compareTimes(a.DueDate, b.DueDate)
+	case SortByCreatedAt:
+		return q.compareTimes(a.CreatedAt, b.CreatedAt)
+	case SortByUpdatedAt:
+		return q.compareTimes(a.UpdatedAt, b.UpdatedAt)
+	default:
+		c = a.Id - b.Id
+	}
+
+	if q.Order == OrderDesc {
+		return -c
+	}
+	return c
+}
+
+// compareTimes compares two optional times, missing times go last no
+// matter which order we sort in
+func (q Query) compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	c := a.Compare(*b)
+	if q.Order == OrderDesc {
+		return -c
+	}
+	return c
+}
+
+// priorityRank turns a priority into a number that sorts the priorities
+// from low to high, an empty or unknown priority is 0
+func priorityRank(priority string) int {
+	switch priority {
+	case PriorityLow:
+		return 1
+	case PriorityMedium:
+		return 2
+	case PriorityHigh:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// hasTag returns true if the item has the tag, ignoring case
+func hasTag(item ToDoItem, tag string) bool {
+	for _, t := range item.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseQueryTime parses the date in the named query parameter, it is
+// nil if the parameter was not provided
+func parseQueryTime(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s must be YYYY-MM-DD or RFC3339, got %q", ErrInvalidQuery, name, value)
+}
@@ -0,0 +1,628 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nitishm/go-rejson/v4"
+	"github.com/nitishm/go-rejson/v4/rjs"
+)
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+	RedisKeyPrefix       = "todo:"
+
+	//RedisScanCount is the COUNT hint we pass to SCAN when we walk
+	//over every item
+	RedisScanCount = 100
+
+	//RedisLastIdKey holds the last id handed out by NextID.  Note it
+	//does not start with RedisKeyPrefix, every todo:* key is an item
+	RedisLastIdKey = "todos:lastid"
+
+	//RedisIndexKey is a sorted set that holds the id of every item,
+	//scored by the id.  It lets us list the items in id order without
+	//looking at every key in redis
+	RedisIndexKey = "todos:index"
+
+	//redisMaxTxRetries bounds how many times a transaction that WATCHes
+	//a key is tried again when another client changed the key
+	redisMaxTxRetries = 10
+)
+
+// bumpLastIdScript moves the id counter forward to ARGV[1] if it is
+// behind it.  Doing this in a lua script makes the compare and set
+// atomic, so it cannot race with INCR from another API instance
+var bumpLastIdScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local id = tonumber(ARGV[1])
+if current < id then
+	redis.call("SET", KEYS[1], id)
+end
+return 0
+`)
+
+// addItemScript adds an item, but only if its key does not exist yet.
+// JSON.SET with NX does the check and the set as one step, so two
+// clients adding the same id at once cannot both win.  When the item is
+// added, its id goes into the index and the id counter is moved past it
+// in the same script, redis runs a script as a single atomic step.
+//
+//	KEYS[1] item key, KEYS[2] index, KEYS[3] id counter
+//	ARGV[1] item JSON, ARGV[2] item id
+//
+// It returns 1 if the item was added and 0 if it already existed
+var addItemScript = redis.NewScript(`
+if not redis.call("JSON.SET", KEYS[1], ".", ARGV[1], "NX") then
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[2])
+local current = tonumber(redis.call("GET", KEYS[3]) or "0")
+local id = tonumber(ARGV[2])
+if current < id then
+	redis.call("SET", KEYS[3], id)
+end
+return 1
+`)
+
+// setItemDoneScript marks an item done or not done.  Instead of reading
+// the whole item and writing it back, it sets just the done flag and
+// the timestamps with JSON.SET on their paths, so it cannot undo a
+// change another client made to the rest of the item in the meantime.
+// CompletedAt is only touched when the done flag changes, and an item
+// that is reopened gets a null completedAt, which loads as no time.
+// The version goes up by one, just like any other change.
+//
+//	KEYS[1] item key
+//	ARGV[1] true or false, ARGV[2] the time of the change as JSON
+//
+// It returns the item as it was stored, or nil if it does not exist
+var setItemDoneScript = redis.NewScript(`
+local done = redis.call("JSON.GET", KEYS[1], ".done")
+if not done then
+	return false
+end
+` + redisItemVersion + `
+redis.call("JSON.SET", KEYS[1], ".version", tostring(tonumber(version) + 1))
+if done ~= ARGV[1] then
+	redis.call("JSON.SET", KEYS[1], ".done", ARGV[1])
+	if ARGV[1] == "true" then
+		redis.call("JSON.SET", KEYS[1], ".completedAt", ARGV[2])
+	else
+		redis.call("JSON.SET", KEYS[1], ".completedAt", "null")
+	end
+end
+redis.call("JSON.SET", KEYS[1], ".updatedAt", ARGV[2])
+return redis.call("JSON.GET", KEYS[1], ".")
+`)
+
+// redisItemVersion is the lua that puts the version of the item at
+// KEYS[1] in the local version.  Items stored before we kept versions
+// do not have one, JSON.GET fails for them, which redis.pcall hands
+// back as an error table instead of stopping the script
+const redisItemVersion = `
+local version = redis.pcall("JSON.GET", KEYS[1], ".version")
+if type(version) ~= "string" then
+	version = "0"
+end
+`
+
+// updateItemScript replaces an item, but only if it is at the version
+// we expect, so the check and the set happen in one atomic step.
+//
+//	KEYS[1] item key
+//	ARGV[1] item JSON, ARGV[2] the version the item must be at
+//
+// It returns 1 if the item was replaced, 0 if it does not exist and -1
+// if it is at another version
+var updateItemScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+` + redisItemVersion + `
+if version ~= ARGV[2] then
+	return -1
+end
+redis.call("JSON.SET", KEYS[1], ".", ARGV[1])
+return 1
+`)
+
+// deleteItemScript deletes an item and takes it out of the index, but
+// only if it is at the version we expect.
+//
+//	KEYS[1] item key, KEYS[2] index
+//	ARGV[1] the version the item must be at, ARGV[2] item id
+//
+// It returns 1 if the item was deleted, 0 if it does not exist and -1
+// if it is at another version
+var deleteItemScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+` + redisItemVersion + `
+if version ~= ARGV[1] then
+	return -1
+end
+redis.call("DEL", KEYS[1])
+redis.call("ZREM", KEYS[2], ARGV[2])
+return 1
+`)
+
+type cache struct {
+	cacheClient *redis.Client
+	jsonHelper  *rejson.Handler
+	context     context.Context
+}
+
+// redisStore is a Store that keeps every item as a JSON document
+// in redis, using the RedisJSON extension module
+type redisStore struct {
+	//Redis cache connections
+	cache
+}
+
+// NewRedisStore returns a Store that is backed by the redis cache
+// at the provided location.
+func NewRedisStore(location string) (Store, error) {
+	store, err := newRedisStore(location)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// newRedisStore does the work for NewRedisStore, it returns the concrete
+// type so the fallback store can get to the redis specific helpers.  If
+// redis is not there the store is returned along with the error, the
+// fallback store can carry on without redis and catch it up later
+func newRedisStore(location string) (*redisStore, error) {
+
+	//Connect to redis.  Other options can be provided, but the
+	//defaults are OK
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	//Time every command we send, see redismetrics.go
+	client.AddHook(redisMetricsHook{})
+
+	//We use this context to coordinate betwen our go code and
+	//the redis operaitons
+	ctx := context.Background()
+
+	//By default, redis manages keys and values, where the values
+	//are either strings, sets, maps, etc.  Redis has an extension
+	//module called ReJSON that allows us to store JSON objects
+	//however, we need a companion library in order to work with it
+	//Below we create an instance of the JSON helper and associate
+	//it with our redis connnection
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+
+	store := &redisStore{
+		cache: cache{
+			cacheClient: client,
+			jsonHelper:  jsonHelper,
+			context:     ctx,
+		},
+	}
+
+	//This is the reccomended way to ensure that our redis connection
+	//is working
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error())
+		return store, err
+	}
+
+	//Items may have been loaded into redis directly, for example by
+	//the cache-data scripts, so make sure the index and the id counter
+	//know about them
+	if err := store.rebuildIndex(); err != nil {
+		log.Println("Error rebuilding the todo index: " + err.Error())
+	}
+
+	//Return a pointer to a new redis store
+	return store, nil
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS
+//------------------------------------------------------------
+
+// redisPingTimeout bounds how long Ping waits for redis to answer
+const redisPingTimeout = time.Second
+
+// Ping returns nil if redis answers a PING within redisPingTimeout
+func (r *redisStore) Ping() error {
+	ctx, cancel := context.WithTimeout(r.context, redisPingTimeout)
+	defer cancel()
+	return r.cacheClient.Ping(ctx).Err()
+}
+
+// isRedisNilError returns true if redis told us the key does not exist
+func isRedisNilError(err error) bool {
+	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
+}
+
+// versionedScriptResult turns the reply of updateItemScript and
+// deleteItemScript into an error
+func versionedScriptResult(res int) error {
+	switch res {
+	case 0:
+		return ErrNotFound
+	case -1:
+		return ErrVersionMismatch
+	default:
+		return nil
+	}
+}
+
+// In redis, our keys will be strings, they will look like
+// todo:<number>.  This function will take an integer and
+// return a string that can be used as a key in redis
+func redisKeyFromId(id int) string {
+	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+}
+
+// Helper to return a ToDoItem from redis provided a key
+func (r *redisStore) getItemFromRedis(key string, item *ToDoItem) error {
+
+	//Lets query redis for the item, note we can return parts of the
+	//json structure, the second parameter "." means return the entire
+	//json structure
+	itemObject, err := r.jsonHelper.JSONGet(key, ".")
+	observeCacheLookup(err)
+	if err != nil {
+		return err
+	}
+
+	//JSONGet returns an "any" object, or empty interface,
+	//we need to convert it to a byte array, which is the
+	//underlying type of the object, then we can unmarshal
+	//it into our ToDoItem struct
+	err = json.Unmarshal(itemObject.([]byte), item)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// bumpLastId makes sure NextID will never hand out id
+func (r *redisStore) bumpLastId(id int) error {
+	return bumpLastIdScript.Run(r.context, r.cacheClient, []string{RedisLastIdKey}, id).Err()
+}
+
+// scanKeys returns every key that matches the pattern.  Unlike KEYS,
+// SCAN walks the keys a batch at a time, so redis is not blocked while
+// it looks at every key in a large database.  SCAN may return a key
+// more than once, so we drop the duplicates
+func (r *redisStore) scanKeys(pattern string) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+
+	var cursor uint64
+	for {
+		batch, next, err := r.cacheClient.Scan(r.context, cursor, pattern, RedisScanCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range batch {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+
+		//SCAN is done when it hands back a cursor of 0
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// getItemsByIds loads the items with the provided ids, which come from
+// the index, using a single JSON.MGET.  Ids whose item is gone, because
+// it was deleted behind our back, are skipped
+func (r *redisStore) getItemsByIds(ids []string) ([]ToDoItem, error) {
+	toDoList := make([]ToDoItem, 0, len(ids))
+	if len(ids) == 0 {
+		return toDoList, nil
+	}
+
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, RedisKeyPrefix+id)
+	}
+
+	//Just like JSONGet, JSONMGet hands back "any", here it is a slice
+	//with one []byte per key, or nil if the key does not exist
+	res, err := r.jsonHelper.JSONMGet(".", keys...)
+	if err != nil {
+		return nil, err
+	}
+	for _, itemObject := range res.([]interface{}) {
+		if itemObject == nil {
+			redisCacheLookups.WithLabelValues("miss").Inc()
+			continue
+		}
+		redisCacheLookups.WithLabelValues("hit").Inc()
+		var toDoItem ToDoItem
+		if err := json.Unmarshal(itemObject.([]byte), &toDoItem); err != nil {
+			return nil, err
+		}
+		toDoList = append(toDoList, toDoItem)
+	}
+	return toDoList, nil
+}
+
+// rebuildIndex adds every item in redis to the index, and moves the id
+// counter past the largest id.  This has to look at every key, so we
+// only do it once when we start up.  Items that are added straight to
+// redis after that, without going through the API, are not listed
+// until the API is restarted
+func (r *redisStore) rebuildIndex() error {
+	ks, err := r.scanKeys(RedisKeyPrefix + "*")
+	if err != nil {
+		return err
+	}
+
+	maxId := 0
+	members := make([]*redis.Z, 0, len(ks))
+	for _, key := range ks {
+		id, err := strconv.Atoi(strings.TrimPrefix(key, RedisKeyPrefix))
+		if err != nil {
+			continue
+		}
+		members = append(members, &redis.Z{Score: float64(id), Member: id})
+		if id > maxId {
+			maxId = id
+		}
+	}
+
+	if len(members) > 0 {
+		if err := r.cacheClient.ZAdd(r.context, RedisIndexKey, members...).Err(); err != nil {
+			return err
+		}
+	}
+
+	return r.bumpLastId(maxId)
+}
+
+//------------------------------------------------------------
+// STORE IMPLEMENTATION
+//------------------------------------------------------------
+
+func (r *redisStore) NextID() (int, error) {
+	//INCR is atomic, so every caller gets a different id, even if
+	//several API instances share the same redis
+	id, err := r.cacheClient.Incr(r.context, RedisLastIdKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+func (r *redisStore) AddItem(item ToDoItem) error {
+
+	//We do not check if the item exists first and then add it, another
+	//client could add the same id in between.  addItemScript checks and
+	//adds in one atomic step, and also updates the index and the id
+	//counter, see the script for the details
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	keys := []string{redisKeyFromId(item.Id), RedisIndexKey, RedisLastIdKey}
+	added, err := addItemScript.Run(r.context, r.cacheClient, keys, string(data), item.Id).Int()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return ErrItemExists
+	}
+
+	//If everything is ok, return nil for the error
+	return nil
+}
+
+func (r *redisStore) DeleteItem(id int, version int) error {
+	if version != AnyVersion {
+		keys := []string{redisKeyFromId(id), RedisIndexKey}
+		deleted, err := deleteItemScript.Run(r.context, r.cacheClient, keys, version, id).Int()
+		if err != nil {
+			return err
+		}
+		return versionedScriptResult(deleted)
+	}
+
+	//Delete the item and take it out of the index in one transaction
+	var delCmd *redis.IntCmd
+	_, err := r.cacheClient.TxPipelined(r.context, func(pipe redis.Pipeliner) error {
+		delCmd = pipe.Del(r.context, redisKeyFromId(id))
+		pipe.ZRem(r.context, RedisIndexKey, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if delCmd.Val() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *redisStore) DeleteAll() error {
+
+	//We read the index and then delete every item in it, along with the
+	//index itself, in one MULTI/EXEC transaction.  We WATCH the index,
+	//so if another client adds or deletes an item after we read it the
+	//transaction is not run, and we try again with the new index
+	deleteAll := func(tx *redis.Tx) error {
+		ids, err := tx.ZRange(r.context, RedisIndexKey, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(ids)+1)
+		for _, id := range ids {
+			keys = append(keys, RedisKeyPrefix+id)
+		}
+		keys = append(keys, RedisIndexKey)
+
+		_, err = tx.TxPipelined(r.context, func(pipe redis.Pipeliner) error {
+			//Note delete can take a collection of keys.  In go we can
+			//expand a slice into individual arguments by using the ...
+			//operator
+			pipe.Del(r.context, keys...)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < redisMaxTxRetries; i++ {
+		err := r.cacheClient.Watch(r.context, deleteAll, RedisIndexKey)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+
+	return errors.New("could not delete all items, they kept changing")
+}
+
+func (r *redisStore) UpdateItem(item ToDoItem, version int) error {
+	if version != AnyVersion {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		updated, err := updateItemScript.Run(r.context, r.cacheClient,
+			[]string{redisKeyFromId(item.Id)}, string(data), version).Int()
+		if err != nil {
+			return err
+		}
+		return versionedScriptResult(updated)
+	}
+
+	//Update the item with JSON Set.  Note there is no update
+	//functionality, so we just overwrite the existing item.  The XX
+	//option tells redis to only set the key if it already exists, so
+	//the check and the set happen in one atomic step, and an item that
+	//was deleted by another client is not brought back to life
+	redisKey := redisKeyFromId(item.Id)
+	res, err := r.jsonHelper.JSONSet(redisKey, ".", item, rjs.SetOptionXX)
+	if err != nil {
+		return err
+	}
+
+	//When XX stops the set redis replies with nil instead of OK
+	if res == nil {
+		return ErrNotFound
+	}
+
+	//If everything is ok, return nil for the error
+	return nil
+}
+
+func (r *redisStore) SetItemDone(id int, done bool, now time.Time) (ToDoItem, error) {
+
+	//The script runs as one step in redis, see setItemDoneScript
+	stamp, err := json.Marshal(now)
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	res, err := setItemDoneScript.Run(r.context, r.cacheClient, []string{redisKeyFromId(id)},
+		strconv.FormatBool(done), string(stamp)).Text()
+	if err != nil && isRedisNilError(err) {
+		return ToDoItem{}, ErrNotFound
+	}
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	var item ToDoItem
+	if err := json.Unmarshal([]byte(res), &item); err != nil {
+		return ToDoItem{}, err
+	}
+	return item, nil
+}
+
+func (r *redisStore) GetItem(id int) (ToDoItem, error) {
+
+	// Check if item exists before trying to get it
+	// this is a good practice, return an error if the
+	// item does not exist
+	var item ToDoItem
+	pattern := redisKeyFromId(id)
+	err := r.getItemFromRedis(pattern, &item)
+	if err != nil && isRedisNilError(err) {
+		return ToDoItem{}, ErrNotFound
+	}
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	return item, nil
+}
+
+func (r *redisStore) GetAllItems() ([]ToDoItem, error) {
+
+	//The index has the id of every item, so listing all of them takes
+	//two round trips to redis, one for the ids and one for the items
+	ids, err := r.cacheClient.ZRange(r.context, RedisIndexKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.getItemsByIds(ids)
+}
+
+func (r *redisStore) GetItemsPage(cursor string, limit int) ([]ToDoItem, string, error) {
+
+	//Just like the map based stores, the cursor is the id of the last
+	//item on the previous page.  The index is sorted by id, so the next
+	//page is the next limit ids after it.  In ZRANGEBYSCORE a ( in front
+	//of the minimum means we do not want the cursor id itself
+	min := "-inf"
+	if cursor != "" {
+		id, err := strconv.Atoi(cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		min = "(" + strconv.Itoa(id)
+	}
+
+	//We ask for one more id than we need, that tells us if there is
+	//another page after this one
+	ids, err := r.cacheClient.ZRangeByScore(r.context, RedisIndexKey, &redis.ZRangeBy{
+		Min:   min,
+		Max:   "+inf",
+		Count: int64(limit + 1),
+	}).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(ids) > limit {
+		ids = ids[:limit]
+		nextCursor = ids[limit-1]
+	}
+
+	toDoList, err := r.getItemsByIds(ids)
+	if err != nil {
+		return nil, "", err
+	}
+	return toDoList, nextCursor, nil
+}
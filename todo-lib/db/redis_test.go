@@ -0,0 +1,382 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/go-redis/redis/v8"
+)
+
+// These tests run the redis store against miniredis, an in memory redis
+// written in go, so they need nothing installed.  miniredis does not have
+// the RedisJSON module, registerJSONStub below adds the few JSON commands
+// the store uses.  To run them against a real redis with RedisJSON set
+// REDIS_URL, for example REDIS_URL=redis://localhost:6379, note the
+// tests empty that redis with FLUSHDB
+
+// newTestRedisStore returns a redis store on an empty redis
+func newTestRedisStore(t *testing.T) *redisStore {
+	t.Helper()
+
+	var location string
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		options, err := redis.ParseURL(url)
+		if err != nil {
+			t.Fatalf("REDIS_URL: %v", err)
+		}
+		location = options.Addr
+	} else {
+		m := miniredis.RunT(t)
+		registerJSONStub(t, m)
+		location = m.Addr()
+	}
+
+	r, err := newRedisStore(location)
+	if err != nil {
+		t.Fatalf("newRedisStore: %v", err)
+	}
+	if err := r.cacheClient.FlushDB(r.context).Err(); err != nil {
+		t.Fatalf("FLUSHDB: %v", err)
+	}
+	t.Cleanup(func() {
+		r.cacheClient.FlushDB(r.context)
+		r.cacheClient.Close()
+	})
+	return r
+}
+
+// registerJSONStub adds JSON.SET, JSON.GET and JSON.MGET to miniredis.
+// A document is kept as a plain string key, so EXISTS and DEL see it
+// just like they see a real JSON key.  Only the root path "." and the
+// top level fields of a document, like ".done", are supported, that is
+// all the store uses.  miniredis runs the commands a lua script calls
+// under the lock the script holds, so the scripts stay atomic
+func registerJSONStub(t *testing.T, m *miniredis.Miniredis) {
+	t.Helper()
+	srv := m.Server()
+
+	//call runs another command for the peer c and returns its reply.
+	//The new peer shares the state of c, so a command called from a
+	//script knows it is in a script
+	call := func(c *server.Peer, args ...string) (interface{}, error) {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		peer := server.NewPeer(w)
+		peer.Ctx = c.Ctx
+		srv.Dispatch(peer, args)
+		w.Flush()
+		return server.ParseReply(bufio.NewReader(&buf))
+	}
+
+	//getField returns the JSON of path in the document at key, or nil
+	//if there is no such key
+	getField := func(c *server.Peer, key, path string) (interface{}, error) {
+		doc, err := call(c, "GET", key)
+		if err != nil || doc == nil || path == "." {
+			return doc, err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(doc.(string)), &fields); err != nil {
+			return nil, err
+		}
+		field, ok := fields[strings.TrimPrefix(path, ".")]
+		if !ok {
+			return nil, fmt.Errorf("ERR Path '%s' does not exist", path)
+		}
+		return string(field), nil
+	}
+
+	jsonSet := func(c *server.Peer, cmd string, args []string) {
+		if len(args) < 3 || !json.Valid([]byte(args[2])) {
+			c.WriteError("ERR wrong arguments for " + cmd)
+			return
+		}
+		key, path, value := args[0], args[1], args[2]
+		doc, err := call(c, "GET", key)
+		if err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+		if len(args) > 3 {
+			switch strings.ToUpper(args[3]) {
+			case "NX":
+				if doc != nil {
+					c.WriteNull()
+					return
+				}
+			case "XX":
+				if doc == nil {
+					c.WriteNull()
+					return
+				}
+			}
+		}
+
+		if path != "." {
+			if doc == nil {
+				c.WriteError("ERR new objects must be created at the root")
+				return
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal([]byte(doc.(string)), &fields); err != nil {
+				c.WriteError(err.Error())
+				return
+			}
+			fields[strings.TrimPrefix(path, ".")] = json.RawMessage(value)
+			data, _ := json.Marshal(fields)
+			value = string(data)
+		}
+		if _, err := call(c, "SET", key, value); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+		c.WriteOK()
+	}
+
+	jsonGet := func(c *server.Peer, cmd string, args []string) {
+		if len(args) < 1 {
+			c.WriteError("ERR wrong arguments for " + cmd)
+			return
+		}
+		path := "."
+		if len(args) > 1 {
+			path = args[1]
+		}
+		res, err := getField(c, args[0], path)
+		switch {
+		case err != nil:
+			c.WriteError(err.Error())
+		case res == nil:
+			c.WriteNull()
+		default:
+			c.WriteBulk(res.(string))
+		}
+	}
+
+	//JSON.MGET key [key ...] path
+	jsonMGet := func(c *server.Peer, cmd string, args []string) {
+		if len(args) < 2 {
+			c.WriteError("ERR wrong arguments for " + cmd)
+			return
+		}
+		keys, path := args[:len(args)-1], args[len(args)-1]
+		c.WriteLen(len(keys))
+		for _, key := range keys {
+			res, err := getField(c, key, path)
+			if err != nil || res == nil {
+				c.WriteNull()
+				continue
+			}
+			c.WriteBulk(res.(string))
+		}
+	}
+
+	for name, cmd := range map[string]server.Cmd{
+		"JSON.SET":  jsonSet,
+		"JSON.GET":  jsonGet,
+		"JSON.MGET": jsonMGet,
+	} {
+		if err := srv.Register(name, cmd); err != nil {
+			t.Fatalf("registering %s: %v", name, err)
+		}
+	}
+}
+
+// checkIndex fails the test if the index does not list exactly the
+// items in redis, and exactly the ids in want
+func checkIndex(t *testing.T, r *redisStore, want ...int) {
+	t.Helper()
+
+	indexed, err := r.cacheClient.ZRange(r.context, RedisIndexKey, 0, -1).Result()
+	if err != nil {
+		t.Fatalf("ZRANGE: %v", err)
+	}
+	keys, err := r.scanKeys(RedisKeyPrefix + "*")
+	if err != nil {
+		t.Fatalf("SCAN: %v", err)
+	}
+	stored := make([]string, 0, len(keys))
+	for _, key := range keys {
+		stored = append(stored, strings.TrimPrefix(key, RedisKeyPrefix))
+	}
+	wanted := make([]string, 0, len(want))
+	for _, id := range want {
+		wanted = append(wanted, strconv.Itoa(id))
+	}
+	sort.Strings(indexed)
+	sort.Strings(stored)
+	sort.Strings(wanted)
+
+	if strings.Join(indexed, ",") != strings.Join(wanted, ",") {
+		t.Errorf("the index has ids [%s], want [%s]", strings.Join(indexed, ","), strings.Join(wanted, ","))
+	}
+	if strings.Join(stored, ",") != strings.Join(wanted, ",") {
+		t.Errorf("redis has items [%s], want [%s]", strings.Join(stored, ","), strings.Join(wanted, ","))
+	}
+}
+
+// TestRedisStoreConcurrentAddsOfOneId adds the same id from two
+// goroutines at once, over and over.  Exactly one of them must win,
+// the other one must get ErrItemExists
+func TestRedisStoreConcurrentAddsOfOneId(t *testing.T) {
+	r := newTestRedisStore(t)
+
+	const rounds = 50
+	for id := 1; id <= rounds; id++ {
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				<-start
+				errs[i] = r.AddItem(ToDoItem{Id: id, Title: fmt.Sprintf("adder %d", i), Version: 1})
+			}(i)
+		}
+		close(start)
+		wg.Wait()
+
+		added, exists := 0, 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				added++
+			case errors.Is(err, ErrItemExists):
+				exists++
+			default:
+				t.Fatalf("AddItem(%d): %v", id, err)
+			}
+		}
+		if added != 1 || exists != 1 {
+			t.Fatalf("adding id %d twice at once: %d added and %d got ErrItemExists, want 1 and 1",
+				id, added, exists)
+		}
+
+		item, err := r.GetItem(id)
+		if err != nil {
+			t.Fatalf("GetItem(%d): %v", id, err)
+		}
+		if item.Title != "adder 0" && item.Title != "adder 1" {
+			t.Fatalf("item %d has title %q", id, item.Title)
+		}
+	}
+
+	ids := make([]int, 0, rounds)
+	for id := 1; id <= rounds; id++ {
+		ids = append(ids, id)
+	}
+	checkIndex(t, r, ids...)
+
+	next, err := r.NextID()
+	if err != nil {
+		t.Fatalf("NextID: %v", err)
+	}
+	if next != rounds+1 {
+		t.Fatalf("NextID handed out %d after adding ids up to %d", next, rounds)
+	}
+}
+
+// TestRedisStoreUpdateOfDeletedItem updates an item after it was
+// deleted, with and without a version.  Both must fail with ErrNotFound
+// and must not bring the item back
+func TestRedisStoreUpdateOfDeletedItem(t *testing.T) {
+	r := newTestRedisStore(t)
+
+	item := ToDoItem{Id: 1, Title: "learn go", Version: 1}
+	if err := r.AddItem(item); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+	if err := r.DeleteItem(item.Id, AnyVersion); err != nil {
+		t.Fatalf("DeleteItem: %v", err)
+	}
+
+	item.Title = "learn more go"
+	item.Version = 2
+	for _, version := range []int{AnyVersion, 1} {
+		if err := r.UpdateItem(item, version); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateItem of a deleted item at version %d: got %v, want ErrNotFound", version, err)
+		}
+	}
+	if _, err := r.SetItemDone(item.Id, true, time.Now().UTC()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetItemDone of a deleted item: got %v, want ErrNotFound", err)
+	}
+
+	exists, err := r.cacheClient.Exists(r.context, redisKeyFromId(item.Id)).Result()
+	if err != nil {
+		t.Fatalf("EXISTS: %v", err)
+	}
+	if exists != 0 {
+		t.Fatalf("the deleted item is back in redis")
+	}
+	if _, err := r.GetItem(item.Id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetItem of a deleted item: got %v, want ErrNotFound", err)
+	}
+	checkIndex(t, r)
+}
+
+// TestRedisStoreIndexAfterDeletes checks that the index lists exactly
+// the items in redis after items are deleted one at a time, and that
+// DeleteAll removes the items and the index together
+func TestRedisStoreIndexAfterDeletes(t *testing.T) {
+	r := newTestRedisStore(t)
+
+	for id := 1; id <= 6; id++ {
+		if err := r.AddItem(ToDoItem{Id: id, Title: fmt.Sprintf("todo %d", id), Version: 1}); err != nil {
+			t.Fatalf("AddItem(%d): %v", id, err)
+		}
+	}
+	checkIndex(t, r, 1, 2, 3, 4, 5, 6)
+
+	if err := r.DeleteItem(2, AnyVersion); err != nil {
+		t.Fatalf("DeleteItem(2): %v", err)
+	}
+	if err := r.DeleteItem(4, 1); err != nil {
+		t.Fatalf("DeleteItem(4) at version 1: %v", err)
+	}
+	if err := r.DeleteItem(5, 7); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("DeleteItem(5) at the wrong version: got %v, want ErrVersionMismatch", err)
+	}
+	if err := r.DeleteItem(2, AnyVersion); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("DeleteItem(2) again: got %v, want ErrNotFound", err)
+	}
+	checkIndex(t, r, 1, 3, 5, 6)
+
+	items, err := r.GetAllItems()
+	if err != nil {
+		t.Fatalf("GetAllItems: %v", err)
+	}
+	if len(items) != 4 {
+		t.Fatalf("GetAllItems returned %d items, want 4", len(items))
+	}
+
+	if err := r.DeleteAll(); err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	checkIndex(t, r)
+	exists, err := r.cacheClient.Exists(r.context, RedisIndexKey).Result()
+	if err != nil {
+		t.Fatalf("EXISTS: %v", err)
+	}
+	if exists != 0 {
+		t.Fatalf("the index is still there after DeleteAll")
+	}
+
+	//The store must keep working after DeleteAll
+	if err := r.AddItem(ToDoItem{Id: 7, Title: "todo 7", Version: 1}); err != nil {
+		t.Fatalf("AddItem after DeleteAll: %v", err)
+	}
+	checkIndex(t, r, 7)
+}
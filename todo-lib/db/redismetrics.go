@@ -0,0 +1,99 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// These are the metrics we keep about our calls to redis, they are
+// served on /metrics along with the HTTP metrics.  Every command goes
+// through redisMetricsHook, including the JSON.* commands that rejson
+// sends for us, so they all get timed without touching each call
+var (
+	redisCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redis_command_duration_seconds",
+		Help:    "How long redis took to answer, by command. Pipelines and transactions are timed as a whole.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"command"})
+
+	redisCommandErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_command_errors_total",
+		Help: "Number of redis commands that failed, by command. A key that does not exist is not an error.",
+	}, []string{"command"})
+
+	redisCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_cache_lookups_total",
+		Help: "Number of JSON.GET lookups, by result, hit or miss.",
+	}, []string{"result"})
+)
+
+// redisPipelineCommand is the command label of pipelines and
+// transactions, they can hold any mix of commands
+const redisPipelineCommand = "pipeline"
+
+// redisStartKey is the context key redisMetricsHook keeps the start
+// time of a command under
+type redisStartKey struct{}
+
+// redisMetricsHook is a go-redis hook that times every command, add it
+// to a client with AddHook
+type redisMetricsHook struct{}
+
+func (redisMetricsHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisMetricsHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	observeRedisCommand(ctx, cmd.Name(), cmd.Err())
+	return nil
+}
+
+func (redisMetricsHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisMetricsHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil && !isKeyMissing(cmd.Err()) {
+			err = cmd.Err()
+			break
+		}
+	}
+	observeRedisCommand(ctx, redisPipelineCommand, err)
+	return nil
+}
+
+// observeRedisCommand records how long the command took, and if it
+// failed for any other reason than a missing key
+func observeRedisCommand(ctx context.Context, command string, err error) {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		redisCommandDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	}
+	if err != nil && !isKeyMissing(err) {
+		redisCommandErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// observeCacheLookup counts a JSON.GET as a hit or a miss, errors
+// other than a missing key are neither, they show up in
+// redis_command_errors_total
+func observeCacheLookup(err error) {
+	switch {
+	case err == nil:
+		redisCacheLookups.WithLabelValues("hit").Inc()
+	case isKeyMissing(err):
+		redisCacheLookups.WithLabelValues("miss").Inc()
+	}
+}
+
+// isKeyMissing returns true if redis told us the key does not exist.
+// rejson does not always wrap redis.Nil, so we also check the message
+func isKeyMissing(err error) bool {
+	return errors.Is(err, redis.Nil) || err.Error() == redis.Nil.Error()
+}
@@ -0,0 +1,427 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// journalFileSuffix is added to the snapshot file name to get the name
+// of the journal, for example ./data/snapshot.json.journal
+const journalFileSuffix = ".journal"
+
+// DefaultSnapshotInterval is how often the in memory items are written
+// out to the snapshot file if no other interval is provided
+const DefaultSnapshotInterval = time.Minute
+
+// These are the operations that are written to the journal
+const (
+	journalAdd       = "add"
+	journalUpdate    = "update"
+	journalDelete    = "delete"
+	journalDeleteAll = "deleteAll"
+)
+
+// journalEntry is one line of the journal file.  Item is set for add
+// and update, Id is set for delete
+type journalEntry struct {
+	Op   string    `json:"op"`
+	Item *ToDoItem `json:"item,omitempty"`
+	Id   int       `json:"id,omitempty"`
+}
+
+// snapshotStore keeps the items in a memoryStore, but makes them survive
+// a restart of the API.  It works like many databases do:
+//
+//  1. Every change is appended to a journal file before it is reported
+//     back to the caller, so nothing acknowledged is ever lost.
+//  2. Every interval, the whole map is written to the snapshot file, using
+//     the same format as the file store, and the journal is emptied.
+//  3. On startup the snapshot is loaded and the journal is replayed on
+//     top of it.
+//
+// Reads go straight to the memory store, so they are just as fast as
+// with the plain memory store.
+type snapshotStore struct {
+	*memoryStore
+
+	snapshotFileName string
+	journalFileName  string
+
+	//journal is the open journal file.  journalMu is held while a change
+	//is applied and written to the journal, so the journal has the
+	//changes in the same order as the map, and while a snapshot is taken
+	journalMu sync.Mutex
+	journal   *os.File
+
+	//stop tells the snapshot loop to return, it closes stopped when it
+	//did.  closeOnce makes sure Close only does its work once
+	stop      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewSnapshotStore returns a Store that keeps the items in memory, and
+// persists them to snapshotFileName plus a journal next to it.  If
+// the files exist, the items in them are loaded.  A snapshot is taken
+// every interval, if interval is 0 DefaultSnapshotInterval is used.
+func NewSnapshotStore(snapshotFileName string, interval time.Duration) (Store, error) {
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+
+	s := &snapshotStore{
+		memoryStore: &memoryStore{
+			toDoMap: make(DbMap),
+			nextId:  1,
+		},
+		snapshotFileName: snapshotFileName,
+		journalFileName:  snapshotFileName + journalFileSuffix,
+		stop:             make(chan struct{}),
+		stopped:          make(chan struct{}),
+	}
+
+	//Bring back the items we had before we were restarted
+	if err := s.restore(); err != nil {
+		return nil, err
+	}
+
+	//Write a fresh snapshot with everything we just restored, this
+	//also leaves us with an empty journal to append to
+	journal, err := os.OpenFile(s.journalFileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = journal
+	if err := s.Snapshot(); err != nil {
+		return nil, err
+	}
+
+	//Now take a snapshot every interval until we are closed
+	go s.snapshotLoop(interval)
+
+	return s, nil
+}
+
+// Close stops taking a snapshot every interval, takes one last snapshot,
+// which leaves the journal empty, and closes the journal.  Changes made
+// after Close fail, there is no journal to write them to.  Call it when
+// the API shuts down
+func (s *snapshotStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.stopped
+
+		s.closeErr = s.Snapshot()
+
+		s.journalMu.Lock()
+		defer s.journalMu.Unlock()
+		if err := s.journal.Close(); s.closeErr == nil {
+			s.closeErr = err
+		}
+	})
+	return s.closeErr
+}
+
+//------------------------------------------------------------
+// STORE IMPLEMENTATION
+//------------------------------------------------------------
+//
+// NextID, GetItem and GetAllItems come from the embedded memoryStore.
+// The ids handed out by NextID are not journaled on their own, the add
+// that follows them is, and that moves the counter past the id on replay.
+//
+// Every change is made in the same three steps while journalMu is held:
+// check that the change can be made, write it to the journal, and only
+// then apply it to the memory store.  Everything that changes the memory
+// store holds journalMu, so nothing can change in between and applying
+// the change cannot fail.  If the journal cannot be written the change
+// is not made at all, so we never serve a change that a restart would
+// lose
+
+func (s *snapshotStore) AddItem(item ToDoItem) error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	if _, err := s.memoryStore.GetItem(item.Id); err == nil {
+		return ErrItemExists
+	}
+	if err := s.appendJournal(journalEntry{Op: journalAdd, Item: &item}); err != nil {
+		return err
+	}
+	return s.memoryStore.AddItem(item)
+}
+
+func (s *snapshotStore) UpdateItem(item ToDoItem, version int) error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	existing, err := s.memoryStore.GetItem(item.Id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return err
+	}
+	if err := s.appendJournal(journalEntry{Op: journalUpdate, Item: &item}); err != nil {
+		return err
+	}
+	return s.memoryStore.UpdateItem(item, version)
+}
+
+// SetItemDone is journaled as an update of the whole item, replaying it
+// then gives the item the same timestamps it got the first time
+func (s *snapshotStore) SetItemDone(id int, done bool, now time.Time) (ToDoItem, error) {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	existing, err := s.memoryStore.GetItem(id)
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	item := existing
+	item.IsDone = done
+	stampUpdatedItem(&item, existing, now)
+
+	if err := s.appendJournal(journalEntry{Op: journalUpdate, Item: &item}); err != nil {
+		return ToDoItem{}, err
+	}
+	if err := s.memoryStore.UpdateItem(item, existing.Version); err != nil {
+		return ToDoItem{}, err
+	}
+	return item, nil
+}
+
+func (s *snapshotStore) DeleteItem(id int, version int) error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	existing, err := s.memoryStore.GetItem(id)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return err
+	}
+	if err := s.appendJournal(journalEntry{Op: journalDelete, Id: id}); err != nil {
+		return err
+	}
+	return s.memoryStore.DeleteItem(id, version)
+}
+
+func (s *snapshotStore) DeleteAll() error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	if err := s.appendJournal(journalEntry{Op: journalDeleteAll}); err != nil {
+		return err
+	}
+	return s.memoryStore.DeleteAll()
+}
+
+// Ping checks that the journal is still there, without it no change
+// can be acknowledged
+func (s *snapshotStore) Ping() error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	_, err := s.journal.Stat()
+	return err
+}
+
+//------------------------------------------------------------
+// SNAPSHOT AND JOURNAL HELPERS
+//------------------------------------------------------------
+
+// Snapshot writes every item to the snapshot file and then empties the
+// journal, because everything in it is now part of the snapshot.
+func (s *snapshotStore) Snapshot() error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	//Take a copy of the map and the id counter under the read lock of
+	//the memory store.  Holding journalMu means nothing can change
+	//while we write the copy out
+	s.memoryStore.mu.RLock()
+	contents := dbFile{
+		NextId: s.memoryStore.nextId,
+		Items:  make([]ToDoItem, 0, len(s.memoryStore.toDoMap)),
+	}
+	for _, item := range s.memoryStore.toDoMap {
+		contents.Items = append(contents.Items, item)
+	}
+	s.memoryStore.mu.RUnlock()
+
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	//Write the snapshot to a temporary file and rename it into place,
+	//so a crash never leaves us with half of a snapshot
+	tmp, err := os.CreateTemp(filepath.Dir(s.snapshotFileName), filepath.Base(s.snapshotFileName)+".tmp*")
+	if err != nil {
+		return err
+	}
+	//CreateTemp makes the file readable by us only, the snapshot gets
+	//the same permissions as the journal and the file store
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.snapshotFileName); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	//If we crash right here the journal is replayed on top of a snapshot
+	//that already has its changes.  That is OK, replaying the journal
+	//gives the same result no matter which of its changes are already
+	//in the snapshot, see applyJournalEntry
+	return s.journal.Truncate(0)
+}
+
+// snapshotLoop takes a snapshot every interval, until Close stops it
+func (s *snapshotStore) snapshotLoop(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Snapshot(); err != nil {
+				log.Println("Error taking a snapshot of the todo items: ", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// appendJournal writes one change to the end of the journal, and waits
+// for it to be on disk.  If that fails whatever made it into the file
+// is cut off again, a partial line would stop the replay on startup,
+// and the changes journaled after it would be lost
+func (s *snapshotStore) appendJournal(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.journal.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = s.journal.Write(append(data, '\n'))
+	if err == nil {
+		err = s.journal.Sync()
+	}
+	if err != nil {
+		if truncErr := s.journal.Truncate(info.Size()); truncErr != nil {
+			log.Printf("Error cutting a failed change off journal %s: %v", s.journalFileName, truncErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// restore loads the snapshot, if there is one, and then replays the
+// journal on top of it
+func (s *snapshotStore) restore() error {
+	data, err := os.ReadFile(s.snapshotFileName)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		//First time we run, nothing to restore
+	case err != nil:
+		return err
+	default:
+		var contents dbFile
+		if err := json.Unmarshal(data, &contents); err != nil {
+			return fmt.Errorf("reading snapshot %s: %w", s.snapshotFileName, err)
+		}
+		if contents.NextId > s.nextId {
+			s.nextId = contents.NextId
+		}
+		for _, item := range contents.Items {
+			s.applyJournalEntry(journalEntry{Op: journalAdd, Item: &item})
+		}
+	}
+
+	journal, err := os.Open(s.journalFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer journal.Close()
+
+	scanner := bufio.NewScanner(journal)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	replayed := 0
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			//A crash in the middle of a write can leave a partial line at
+			//the end of the journal, that change was never acknowledged
+			//so we stop here
+			log.Printf("Ignoring the rest of journal %s after %d entries: %v", s.journalFileName, replayed, err)
+			break
+		}
+		s.applyJournalEntry(entry)
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if replayed > 0 {
+		log.Printf("Replayed %d changes from journal %s", replayed, s.journalFileName)
+	}
+	return nil
+}
+
+// applyJournalEntry applies a change directly to the memory map.  Adds
+// and updates just set the item, so applying a change twice, or on top
+// of a snapshot that already has it, does no harm
+func (s *snapshotStore) applyJournalEntry(entry journalEntry) {
+	switch entry.Op {
+	case journalAdd, journalUpdate:
+		if entry.Item == nil {
+			return
+		}
+		s.toDoMap[entry.Item.Id] = *entry.Item
+		if entry.Item.Id >= s.nextId {
+			s.nextId = entry.Item.Id + 1
+		}
+	case journalDelete:
+		delete(s.toDoMap, entry.Id)
+	case journalDeleteAll:
+		s.toDoMap = make(DbMap)
+	default:
+		log.Printf("Ignoring unknown journal operation %q", entry.Op)
+	}
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSnapshotStoreJournalFailure closes the journal under the store, so
+// every write to it fails.  A change that cannot be journaled would be
+// lost on a restart, so it must not be made in memory either
+func TestSnapshotStoreJournalFailure(t *testing.T) {
+	snapshotFile := filepath.Join(t.TempDir(), "snapshot.json")
+	store, err := NewSnapshotStore(snapshotFile, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	s := store.(*snapshotStore)
+
+	item := ToDoItem{Id: 1, Title: "learn go", Version: 1}
+	if err := s.AddItem(item); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+
+	s.journal.Close()
+
+	if err := s.AddItem(ToDoItem{Id: 2, Title: "learn redis", Version: 1}); err == nil {
+		t.Errorf("AddItem succeeded without a journal")
+	}
+	if _, err := s.GetItem(2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("item 2 is in memory after its add failed, GetItem returned %v", err)
+	}
+
+	updated := ToDoItem{Id: 1, Title: "learn go well", Version: 2}
+	if err := s.UpdateItem(updated, 1); err == nil {
+		t.Errorf("UpdateItem succeeded without a journal")
+	}
+	if _, err := s.SetItemDone(1, true, time.Now()); err == nil {
+		t.Errorf("SetItemDone succeeded without a journal")
+	}
+	if err := s.DeleteItem(1, AnyVersion); err == nil {
+		t.Errorf("DeleteItem succeeded without a journal")
+	}
+	if err := s.DeleteAll(); err == nil {
+		t.Errorf("DeleteAll succeeded without a journal")
+	}
+
+	got, err := s.GetItem(1)
+	if err != nil {
+		t.Fatalf("item 1 is gone after its delete failed, GetItem returned %v", err)
+	}
+	if got.Title != item.Title || got.Version != item.Version || got.IsDone {
+		t.Errorf("item 1 is %+v after its changes failed, want %+v", got, item)
+	}
+}
+
+// TestSnapshotFilePermissions checks the snapshot can be read by others,
+// like the file store and the journal, and not just by us
+func TestSnapshotFilePermissions(t *testing.T) {
+	snapshotFile := filepath.Join(t.TempDir(), "snapshot.json")
+	if _, err := NewSnapshotStore(snapshotFile, time.Hour); err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+
+	info, err := os.Stat(snapshotFile)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("snapshot file has permissions %o, want 644", perm)
+	}
+}
+
+// TestSnapshotStoreClose checks Close stops the snapshot loop and takes a
+// last snapshot, so a new store restores every change from the snapshot
+// alone, with nothing left in the journal
+func TestSnapshotStoreClose(t *testing.T) {
+	snapshotFile := filepath.Join(t.TempDir(), "snapshot.json")
+	store, err := NewSnapshotStore(snapshotFile, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	s := store.(*snapshotStore)
+
+	item := ToDoItem{Id: 1, Title: "learn go", Version: 1}
+	if err := s.AddItem(item); err != nil {
+		t.Fatalf("AddItem: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("a second Close returned %v", err)
+	}
+
+	select {
+	case <-s.stopped:
+	default:
+		t.Errorf("the snapshot loop is still running after Close")
+	}
+	info, err := os.Stat(s.journalFileName)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("the journal has %d bytes after Close, want none", info.Size())
+	}
+	if err := s.AddItem(ToDoItem{Id: 2, Title: "learn redis", Version: 1}); err == nil {
+		t.Errorf("AddItem succeeded after Close")
+	}
+
+	restored, err := NewSnapshotStore(snapshotFile, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSnapshotStore: %v", err)
+	}
+	defer restored.(*snapshotStore).Close()
+	got, err := restored.GetItem(1)
+	if err != nil {
+		t.Fatalf("GetItem after a restart: %v", err)
+	}
+	if got.Title != item.Title || got.Version != item.Version {
+		t.Errorf("item 1 came back as %+v, want %+v", got, item)
+	}
+}
@@ -0,0 +1,743 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ToDoItem is the struct that represents a single ToDo item.  Everything
+// after IsDone was added later, so those fields are optional in JSON
+// and older records that do not have them still load.  The timestamps
+// are maintained by the db package, whatever a client sends for them
+// is overwritten.  The same goes for Version, which starts at 1 and
+// goes up by one every time the item changes, the API sends it to
+// clients as the ETag of the item.
+//
+// The validate tags are the rules an item must follow to be stored,
+// see ValidateItem.  An id of 0 means the item does not have one yet
+type ToDoItem struct {
+	Id          int        `json:"id" validate:"gte=0"`
+	Title       string     `json:"title" validate:"notblank,max=200"`
+	IsDone      bool       `json:"done"`
+	Description string     `json:"description,omitempty" validate:"max=2000"`
+	Priority    string     `json:"priority,omitempty" validate:"omitempty,oneof=low medium high"`
+	Tags        []string   `json:"tags,omitempty" validate:"max=20,dive,notblank,max=50"`
+	DueDate     *time.Time `json:"dueDate,omitempty"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	Version     int        `json:"version,omitempty"`
+}
+
+// These are the priorities a ToDoItem can have, an empty Priority
+// means that no priority was set
+const (
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+)
+
+// Store is the interface that every storage backend for our todo
+// app implements.  The ToDo struct below only talks to a Store, so
+// the api package does not care if the items live in memory, in a
+// JSON file or in redis.  This also lets us swap in the memory
+// store when we want to exercise the API without any infrastructure.
+//
+// NextID hands out a new item id each time it is called, and must be
+// safe to call from many clients at once.  Stores also make sure that
+// an id used by AddItem will not be handed out by NextID later on.
+//
+// GetItemsPage returns up to limit items starting at cursor, along with
+// the cursor of the next page.  An empty cursor starts at the beginning,
+// and an empty next cursor means there are no more items.  Cursors are
+// opaque, every store decides what goes in them.
+//
+// Ping checks that the store can be used right now, for example that
+// the database file can be read or that redis answers.  The /readyz
+// endpoint uses it to tell if the API should be sent any traffic.
+//
+// UpdateItem and DeleteItem only change the item if it is at the
+// version passed in, otherwise they return ErrVersionMismatch, so two
+// clients cannot overwrite each other without noticing.  AnyVersion
+// skips the check.  Stores do not set the version of an updated item,
+// the ToDo functions below do that.
+//
+// SetItemDone marks an item done or not done, and stamps it with now,
+// and bumps its version, as one step.  It returns the item as it was
+// stored.  Stores that can change part of an item in place, like redis,
+// do not have to read and rewrite the whole item.
+type Store interface {
+	NextID() (int, error)
+	AddItem(item ToDoItem) error
+	UpdateItem(item ToDoItem, version int) error
+	DeleteItem(id int, version int) error
+	DeleteAll() error
+	GetItem(id int) (ToDoItem, error)
+	GetAllItems() ([]ToDoItem, error)
+	GetItemsPage(cursor string, limit int) ([]ToDoItem, string, error)
+	SetItemDone(id int, done bool, now time.Time) (ToDoItem, error)
+	Ping() error
+}
+
+// These are the names of the storage backends that can be selected
+// at startup, either with the TODO_STORE environment variable or
+// with the -s command line flag
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+	StoreRedis  = "redis"
+
+	DefaultStore  = StoreMemory
+	DefaultDbFile = "./data/todo.json"
+)
+
+// These are the errors the stores and the functions below return, so
+// callers like the api package can find out what went wrong with
+// errors.Is instead of looking at the message.  They are often wrapped
+// with more detail, so always use errors.Is to check for them
+
+// ErrNotFound is returned when there is no item with the requested id
+var ErrNotFound = errors.New("item does not exist")
+
+// ErrItemExists is returned by the stores when adding an item whose
+// id is already in use
+var ErrItemExists = errors.New("item already exists")
+
+// ErrVersionMismatch is returned when an item was asked to be changed
+// at a version it is no longer at, someone else changed it first
+var ErrVersionMismatch = errors.New("item version does not match")
+
+// ErrInvalidItem is returned when an item cannot be stored the way it
+// is, it is wrapped with the reason
+var ErrInvalidItem = errors.New("invalid item")
+
+// ErrInvalidCursor is returned by GetItemsPage when the cursor was not
+// handed out by the store
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// These bound the number of items GetItemsPage returns, a limit of 0
+// means DefaultPageLimit
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+// maxIdRetries bounds how many times AddItem asks the store for a new
+// id when the one it got is already taken.  That can only happen if an
+// item with an explicit id slipped in at the same time, or if items
+// were loaded into the store behind our back
+const maxIdRetries = 10
+
+// AnyVersion is passed as the version to UpdateItem, DeleteItem and
+// PatchItem to change the item whatever version it is at
+const AnyVersion = -1
+
+// maxVersionRetries bounds how many times an update with AnyVersion is
+// tried again when the item was changed by someone else between reading
+// it and writing it back
+const maxVersionRetries = 10
+
+// ToDo is the struct that represents the main object of our
+// todo app.  It contains a reference to the Store that actually
+// holds the items
+type ToDo struct {
+	//more things would be included in a real implementation
+
+	store Store
+}
+
+// Options are the choices that differ between our todo APIs, each of
+// them passes its own to NewWithOptions
+type Options struct {
+	//DefaultStore is the store used when neither the caller nor the
+	//TODO_STORE environment variable pick one
+	DefaultStore string
+
+	//RedisFallback keeps the API working from a local copy of the items
+	//while redis is not available, see NewWithCacheFallback
+	RedisFallback bool
+}
+
+// DefaultOptions are the options New and NewWithStoreType use, they
+// keep the items in memory unless another store is picked
+func DefaultOptions() Options {
+	return Options{DefaultStore: DefaultStore}
+}
+
+// New is a constructor function that returns a pointer to a new
+// ToDo struct.  The storage backend is taken from the TODO_STORE
+// environment variable, and defaults to an in memory map if it is
+// not set.
+func New() (*ToDo, error) {
+	return NewWithStoreType("")
+}
+
+// NewWithStoreType is a constructor function that returns a pointer to
+// a new ToDo struct backed by the named store, using the DefaultOptions
+func NewWithStoreType(storeType string) (*ToDo, error) {
+	return NewWithOptions(storeType, DefaultOptions())
+}
+
+// NewWithOptions is a constructor function that returns a pointer to a
+// new ToDo struct backed by the named store.  If storeType is empty the
+// TODO_STORE environment variable is used, and if that is not set we
+// fall back to the DefaultStore of the options.  Just like REDIS_URL,
+// the location of the file store can be overridden with the
+// TODO_DB_FILE environment variable.
+func NewWithOptions(storeType string, options Options) (*ToDo, error) {
+	if storeType == "" {
+		storeType = os.Getenv("TODO_STORE")
+	}
+	if storeType == "" {
+		storeType = options.DefaultStore
+	}
+
+	switch storeType {
+	case StoreMemory:
+		return NewWithStore(NewMemoryStore()), nil
+	case StoreFile:
+		dbFile := os.Getenv("TODO_DB_FILE")
+		if dbFile == "" {
+			dbFile = DefaultDbFile
+		}
+		store, err := NewFileStore(dbFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewWithStore(store), nil
+	case StoreRedis:
+		//We will use an override if the REDIS_URL is provided as an environment
+		//variable, which is the preferred way to wire up a docker container
+		redisUrl := os.Getenv("REDIS_URL")
+		//This handles the default condition
+		if redisUrl == "" {
+			redisUrl = RedisDefaultLocation
+		}
+		if options.RedisFallback {
+			return NewWithCacheFallback(redisUrl)
+		}
+		return NewWithCacheInstance(redisUrl)
+	default:
+		return nil, fmt.Errorf("unknown store type %q, must be one of %s, %s or %s",
+			storeType, StoreMemory, StoreFile, StoreRedis)
+	}
+}
+
+// NewWithCacheInstance is a constructor function that returns a pointer to a new
+// ToDo struct backed by redis.  It accepts a string that represents the location
+// of the redis cache.
+func NewWithCacheInstance(location string) (*ToDo, error) {
+	store, err := NewRedisStore(location)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithStore(store), nil
+}
+
+// NewWithCacheFallback works just like NewWithCacheInstance, but if
+// redis is not available, now or later on, the API keeps working in a
+// degraded mode from a local copy of the items, and catches redis up
+// once it is back, see fallbackStore.
+func NewWithCacheFallback(location string) (*ToDo, error) {
+	//If redis is not there yet the fallback store starts out degraded
+	store, _ := newRedisStore(location)
+	return NewWithStore(newFallbackStore(store)), nil
+}
+
+// NewWithSnapshots is a constructor function that returns a pointer to
+// a new ToDo struct that keeps the items in memory, but snapshots them
+// to snapshotFile every interval and journals every change in between,
+// see NewSnapshotStore.  Items from an earlier run are restored.
+func NewWithSnapshots(snapshotFile string, interval time.Duration) (*ToDo, error) {
+	store, err := NewSnapshotStore(snapshotFile, interval)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithStore(store), nil
+}
+
+// NewWithStore is a constructor function that returns a pointer to a new
+// ToDo struct that uses the provided store
+func NewWithStore(store Store) *ToDo {
+	return &ToDo{
+		store: store,
+	}
+}
+
+//------------------------------------------------------------
+// THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
+//------------------------------------------------------------
+
+// AddItem accepts a ToDoItem and adds it to the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must not already exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if so, return an error
+//
+// Postconditions:
+//
+//	 (1) The item will be added to the DB
+//		(2) The DB file will be saved with the item added
+//		(3) If there is an error, it will be returned
+//		(4) If the item did not have an id (it is 0), the store
+//			allocates the next free id.  The item as it was stored,
+//			including its id and timestamps, is returned
+//		(5) An item that breaks the validation rules on ToDoItem is
+//			not added, a *ValidationError is returned instead
+func (t *ToDo) AddItem(item ToDoItem) (ToDoItem, error) {
+	if err := ValidateItem(item); err != nil {
+		return ToDoItem{}, err
+	}
+
+	stampNewItem(&item, time.Now().UTC())
+	return t.addStamped(item)
+}
+
+// addStamped stores an item that was already validated and stamped, if
+// it does not have an id the store hands out the next free one
+func (t *ToDo) addStamped(item ToDoItem) (ToDoItem, error) {
+	if item.Id != 0 {
+		if err := t.store.AddItem(item); err != nil {
+			return ToDoItem{}, err
+		}
+		return item, nil
+	}
+
+	for i := 0; i < maxIdRetries; i++ {
+		id, err := t.store.NextID()
+		if err != nil {
+			return ToDoItem{}, err
+		}
+
+		item.Id = id
+		err = t.store.AddItem(item)
+		if err == nil {
+			return item, nil
+		}
+		if !errors.Is(err, ErrItemExists) {
+			return ToDoItem{}, err
+		}
+	}
+
+	return ToDoItem{}, fmt.Errorf("could not allocate an id after %d attempts", maxIdRetries)
+}
+
+// DeleteItem accepts an item id and removes it from the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+// Postconditions:
+//
+//	 (1) The item will be removed from the DB
+//		(2) The DB file will be saved with the item removed
+//		(3) If there is an error, it will be returned
+//		(4) Unless version is AnyVersion, the item is only removed if
+//			it is at that version, if not ErrVersionMismatch is returned
+func (t *ToDo) DeleteItem(id int, version int) error {
+	return t.store.DeleteItem(id, version)
+}
+
+// DeleteAll removes all items from the DB.
+// It will be exposed via a DELETE /todo endpoint
+func (t *ToDo) DeleteAll() error {
+	return t.store.DeleteAll()
+}
+
+// UpdateItem accepts a ToDoItem and updates it in the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+// Postconditions:
+//
+//	 (1) The item will be updated in the DB
+//		(2) The DB file will be saved with the item updated
+//		(3) If there is an error, it will be returned
+//		(4) The creation time is kept from the existing item and the
+//			other timestamps are updated.  The item as it was stored
+//			is returned
+//		(5) An item that breaks the validation rules on ToDoItem is
+//			not updated, a *ValidationError is returned instead
+//		(6) Unless version is AnyVersion, the item is only updated if
+//			it is at that version, if not ErrVersionMismatch is returned
+//		(7) The version of the item goes up by one
+func (t *ToDo) UpdateItem(item ToDoItem, version int) (ToDoItem, error) {
+	if item.Id <= 0 {
+		return ToDoItem{}, fmt.Errorf("%w: the id of the item to update is required", ErrInvalidItem)
+	}
+	if err := ValidateItem(item); err != nil {
+		return ToDoItem{}, err
+	}
+
+	return t.updateVersioned(item.Id, version, func(existing ToDoItem) (ToDoItem, error) {
+		return item, nil
+	})
+}
+
+// GetItem accepts an item id and returns the item from the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+// Postconditions:
+//
+//	 (1) The item will be returned, if it exists
+//		(2) If there is an error, it will be returned
+//			along with an empty ToDoItem
+//		(3) The database file will not be modified
+func (t *ToDo) GetItem(id int) (ToDoItem, error) {
+	return t.store.GetItem(id)
+}
+
+// ChangeItemDoneStatus accepts an item id and a boolean status.
+// It returns an error if the status could not be updated for any
+// reason.  For example, the item itself does not exist, or an
+// IO error trying to save the updated status.
+
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+// Postconditions:
+//
+//	 (1) The items status in the database will be updated
+//		(2) If there is an error, it will be returned.
+//		(3) The store changes the status and the timestamps as one
+//			step, so a client that changes another field of the item
+//			at the same time does not lose its change.  The item as it
+//			was stored is returned
+func (t *ToDo) ChangeItemDoneStatus(id int, value bool) (ToDoItem, error) {
+	return t.store.SetItemDone(id, value, time.Now().UTC())
+}
+
+// PatchItem accepts an item id and a JSON Merge Patch, see RFC 7396,
+// and changes just the fields of the item that are in the patch.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB, if not,
+//	    				ErrNotFound is returned
+//
+// Postconditions:
+//
+//	 (1) The patched item will be updated in the DB, and returned
+//		(2) A patch that is not a JSON object, or gives a field a value
+//			of the wrong type, returns ErrInvalidPatch
+//		(3) The patched item must follow the validation rules on
+//			ToDoItem, and its id cannot be changed, if not the item is
+//			not updated and ErrInvalidItem is returned
+//		(4) Timestamps and the version in the patch are ignored, just
+//			like UpdateItem
+//		(5) Unless version is AnyVersion, the item is only patched if
+//			it is at that version, if not ErrVersionMismatch is returned
+func (t *ToDo) PatchItem(id int, patch []byte, version int) (ToDoItem, error) {
+	return t.updateVersioned(id, version, func(existing ToDoItem) (ToDoItem, error) {
+		doc, err := json.Marshal(existing)
+		if err != nil {
+			return ToDoItem{}, err
+		}
+		merged, err := mergePatch(doc, patch)
+		if err != nil {
+			return ToDoItem{}, err
+		}
+
+		//DecodeItem reports fields a todo does not have, anything else
+		//it does not like is a value of the wrong type in the patch
+		item, err := DecodeItem(merged)
+		if err != nil && !errors.Is(err, ErrInvalidItem) {
+			return ToDoItem{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		if err != nil {
+			return ToDoItem{}, err
+		}
+		if item.Id != id {
+			return ToDoItem{}, fmt.Errorf("%w: the id of an item cannot be changed", ErrInvalidItem)
+		}
+		if err := ValidateItem(item); err != nil {
+			return ToDoItem{}, err
+		}
+		return item, nil
+	})
+}
+
+// GetAllItems returns all items from the DB.  If successful it
+// returns a slice of all of the items to the caller
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) All items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty slice
+//		(3) The database file will not be modified
+func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
+	return t.store.GetAllItems()
+}
+
+// GetItemsPage returns one page of items from the DB, so large lists
+// can be handed out a bit at a time.  Pass the returned cursor back in
+// to get the next page, any other cursor returns ErrInvalidCursor.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) Up to limit items will be returned, limit is capped at
+//			MaxPageLimit and 0 means DefaultPageLimit
+//		(2) The cursor of the next page is returned, it is empty if
+//			this was the last page
+//		(3) The database file will not be modified
+func (t *ToDo) GetItemsPage(cursor string, limit int) ([]ToDoItem, string, error) {
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	return t.store.GetItemsPage(cursor, limit)
+}
+
+// Ping checks that the store behind this ToDo can be used right now,
+// it returns the reason if it cannot
+func (t *ToDo) Ping() error {
+	return t.store.Ping()
+}
+
+// Close releases the store behind this ToDo, for example the snapshot
+// store stops taking snapshots and takes a last one.  Stores that have
+// nothing to release do nothing
+func (t *ToDo) Close() error {
+	if closer, ok := t.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Status reports the health of the store behind this ToDo, for example
+// if it is running in degraded mode because redis is not available.
+// Stores that only have one mode are always ModeNormal
+func (t *ToDo) Status() StoreStatus {
+	if reporter, ok := t.store.(interface{ Status() StoreStatus }); ok {
+		return reporter.Status()
+	}
+	return StoreStatus{Mode: ModeNormal}
+}
+
+// ImportItems reads todos in the format provided, one of the Format
+// constants, and adds every one of them to the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) Every todo that can be read and added is added.  A todo
+//			that cannot is skipped, and the reason is in the errors
+//			of the returned ImportResult, along with where it is in
+//			the import
+//		(2) Todos are checked and given an id just like AddItem, but
+//			the timestamps they have are kept, so todos can be moved
+//			from one store to another.  Their version starts at 1
+//		(3) If the data cannot be read at all, nothing is added and
+//			ErrInvalidImport or ErrUnknownFormat is returned
+func (t *ToDo) ImportItems(r io.Reader, format string) (ImportResult, error) {
+	return t.ImportItemsFunc(r, format, nil)
+}
+
+// ImportItemsFunc works just like ImportItems, but it also calls added
+// with every todo right after it was added, the way it was stored, so
+// the caller can tell others about it.  added may be nil
+func (t *ToDo) ImportItemsFunc(r io.Reader, format string, added func(item ToDoItem)) (ImportResult, error) {
+	rows, err := readImport(r, format)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	result := newImportResult()
+	now := time.Now().UTC()
+	for _, row := range rows {
+		if row.err != nil {
+			result.failed(row, row.err)
+			continue
+		}
+		if err := ValidateItem(row.item); err != nil {
+			result.failed(row, err)
+			continue
+		}
+
+		item := row.item
+		stampImportedItem(&item, now)
+		item, err := t.addStamped(item)
+		if err != nil {
+			result.failed(row, err)
+			continue
+		}
+		result.succeeded(item)
+		if added != nil {
+			added(item)
+		}
+	}
+	return result, nil
+}
+
+// ExportItems writes every todo in the DB to w in the format provided,
+// one of the Format constants, in id order.  Versions are not exported,
+// they only mean something to the store the todos came from
+func (t *ToDo) ExportItems(w io.Writer, format string) error {
+	items, err := t.store.GetAllItems()
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		items[i].Version = 0
+	}
+	return writeExport(w, format, items)
+}
+
+// PrintItem accepts a ToDoItem and prints it to the console
+// in a JSON pretty format. As some help, look at the
+// json.MarshalIndent() function from our in class go tutorial.
+func (t *ToDo) PrintItem(item ToDoItem) {
+	jsonBytes, _ := json.MarshalIndent(item, "", "  ")
+	fmt.Println(string(jsonBytes))
+}
+
+// PrintAllItems accepts a slice of ToDoItems and prints them to the console
+// in a JSON pretty format.  It should call PrintItem() to print each item
+// versus repeating the code.
+func (t *ToDo) PrintAllItems(itemList []ToDoItem) {
+	for _, item := range itemList {
+		t.PrintItem(item)
+	}
+}
+
+// JsonToItem accepts a json string and returns a ToDoItem
+// This is helpful because the CLI accepts todo items for insertion
+// and updates in JSON format.  We need to convert it to a ToDoItem
+// struct to perform any operations on it.
+//
+// Unknown fields and items that break the validation rules are
+// rejected, the error is a *ValidationError with the details.
+func (t *ToDo) JsonToItem(jsonString string) (ToDoItem, error) {
+	item, err := DecodeItem([]byte(jsonString))
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	if err := ValidateItem(item); err != nil {
+		return ToDoItem{}, err
+	}
+	return item, nil
+}
+
+//------------------------------------------------------------
+// ITEM HELPERS
+//------------------------------------------------------------
+
+// updateVersioned reads the item, has change work out what it should
+// look like now, and writes it back only if nobody changed it in the
+// meantime.  With a version, the item must be at that version, and if
+// someone changes it first ErrVersionMismatch is returned.  With
+// AnyVersion we just read the item again and have another go, that way
+// an update never silently undoes a change that landed in between
+func (t *ToDo) updateVersioned(id int, version int, change func(existing ToDoItem) (ToDoItem, error)) (ToDoItem, error) {
+	for i := 0; i < maxVersionRetries; i++ {
+		existing, err := t.store.GetItem(id)
+		if err != nil {
+			return ToDoItem{}, err
+		}
+		if version != AnyVersion && existing.Version != version {
+			return ToDoItem{}, ErrVersionMismatch
+		}
+
+		item, err := change(existing)
+		if err != nil {
+			return ToDoItem{}, err
+		}
+
+		stampUpdatedItem(&item, existing, time.Now().UTC())
+		err = t.store.UpdateItem(item, existing.Version)
+		if err == nil {
+			return item, nil
+		}
+		if version != AnyVersion || !errors.Is(err, ErrVersionMismatch) {
+			return ToDoItem{}, err
+		}
+	}
+
+	return ToDoItem{}, fmt.Errorf("could not update item %d, it kept changing", id)
+}
+
+// checkVersion returns ErrVersionMismatch if the stored item is not at
+// version, the stores use it to implement the version check of
+// UpdateItem and DeleteItem
+func checkVersion(stored ToDoItem, version int) error {
+	if version != AnyVersion && stored.Version != version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// stampNewItem sets the timestamps of an item that is about to be
+// added to the DB
+func stampNewItem(item *ToDoItem, now time.Time) {
+	item.Version = 1
+	item.CreatedAt = &now
+	item.UpdatedAt = &now
+	item.CompletedAt = nil
+	if item.IsDone {
+		item.CompletedAt = &now
+	}
+}
+
+// stampImportedItem sets the timestamps an imported item does not have,
+// the ones it has are kept.  A todo that is not done was never
+// completed, and the version starts over, just like for a new item
+func stampImportedItem(item *ToDoItem, now time.Time) {
+	item.Version = 1
+	if item.CreatedAt == nil {
+		item.CreatedAt = &now
+	}
+	if item.UpdatedAt == nil {
+		item.UpdatedAt = item.CreatedAt
+	}
+	switch {
+	case !item.IsDone:
+		item.CompletedAt = nil
+	case item.CompletedAt == nil:
+		item.CompletedAt = &now
+	}
+}
+
+// stampUpdatedItem sets the timestamps of an item that is about to
+// replace existing in the DB.  CreatedAt always comes from the existing
+// item, CompletedAt only changes when the done status changes, and the
+// version is one more than the version of the existing item
+func stampUpdatedItem(item *ToDoItem, existing ToDoItem, now time.Time) {
+	item.Version = existing.Version + 1
+	item.CreatedAt = existing.CreatedAt
+	item.UpdatedAt = &now
+	switch {
+	case !item.IsDone:
+		item.CompletedAt = nil
+	case existing.IsDone && existing.CompletedAt != nil:
+		item.CompletedAt = existing.CompletedAt
+	default:
+		item.CompletedAt = &now
+	}
+}
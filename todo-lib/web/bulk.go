@@ -0,0 +1,42 @@
+package web
+
+import (
+	"mime"
+
+	"drexel.edu/todo-lib/db"
+	"github.com/gin-gonic/gin"
+)
+
+// MaxImportBytes bounds the size of the body of POST /todo/bulk
+const MaxImportBytes = 32 << 20
+
+// FormatContentTypes are the content types of the import and export
+// formats
+var FormatContentTypes = map[string]string{
+	db.FormatJSON:   "application/json",
+	db.FormatCSV:    "text/csv",
+	db.FormatNDJSON: "application/x-ndjson",
+}
+
+// ImportFormat returns the format of the body of an import.  The format
+// query parameter wins, otherwise we go by the Content-Type header, and
+// if that does not tell us either it is JSON
+func ImportFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return format
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	for format, contentType := range FormatContentTypes {
+		if mediaType == contentType {
+			return format
+		}
+	}
+	return db.FormatJSON
+}
+
+// ExportFormat returns the format an export was asked for in, JSON if
+// the format query parameter is not there
+func ExportFormat(c *gin.Context) string {
+	return c.DefaultQuery("format", db.FormatJSON)
+}
@@ -0,0 +1,211 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"drexel.edu/todo-lib/db"
+	"github.com/gin-gonic/gin"
+)
+
+// These are the codes we send back in error responses.  Clients can
+// rely on them, the messages that go with them are just for people
+const (
+	CodeBadRequest         = "bad_request"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodePreconditionFailed = "precondition_failed"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeValidationFailed   = "validation_failed"
+	CodeInternalError      = "internal_error"
+	CodeUnavailable        = "unavailable"
+)
+
+// RequestIdHeader is the header the request id is read from and sent
+// back in.  A client or a proxy in front of us can pick the id, so the
+// same id shows up in every log the request passed through
+const RequestIdHeader = "X-Request-Id"
+
+// requestIdKey is the key the request id is kept under in the gin
+// context
+const requestIdKey = "requestId"
+
+// validRequestId limits the ids we take from clients, they end up in
+// our logs and response headers so they must be short and plain
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ErrorResponse is the body of every error the API sends back, for
+// example:
+//
+//	{"error": {"code": "not_found", "message": "item does not exist", "requestId": "5f1c..."}}
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail says what went wrong, Code is one of the Code constants.
+// When a todo fails validation Fields says which of its fields broke
+// which rule
+type ErrorDetail struct {
+	Code      string          `json:"code"`
+	Message   string          `json:"message"`
+	RequestId string          `json:"requestId"`
+	Fields    []db.FieldError `json:"fields,omitempty"`
+}
+
+// RequestId returns a gin middleware that gives every request an id,
+// the one in the X-Request-Id header if the client sent a valid one,
+// or a new random one.  The id is sent back in the X-Request-Id header
+// and in error responses, and it is in the log lines of failed requests
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIdHeader)
+		if !validRequestId.MatchString(id) {
+			id = newRequestId()
+		}
+		c.Set(requestIdKey, id)
+		c.Header(RequestIdHeader, id)
+		c.Next()
+	}
+}
+
+// GetRequestId returns the id RequestId gave the request
+func GetRequestId(c *gin.Context) string {
+	return c.GetString(requestIdKey)
+}
+
+// newRequestId returns a random 32 character hex id
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		//This should never happen, but an id that is only mostly
+		//unique is better than none
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// AbortWithError logs what went wrong, and sends err back to the client
+// with the status and code provided
+func AbortWithError(c *gin.Context, status int, code string, what string, err error) {
+	requestId := GetRequestId(c)
+	log.Printf("[%s] %s: %v", requestId, what, err)
+
+	c.AbortWithStatusJSON(status, ErrorResponse{Error: NewErrorDetail(code, err, requestId)})
+}
+
+// NewErrorDetail describes err to the client, with the fields that
+// broke a rule if it is a *db.ValidationError
+func NewErrorDetail(code string, err error, requestId string) ErrorDetail {
+	detail := ErrorDetail{
+		Code:      code,
+		Message:   err.Error(),
+		RequestId: requestId,
+	}
+	var verr *db.ValidationError
+	if errors.As(err, &verr) {
+		detail.Fields = verr.Fields
+	}
+	return detail
+}
+
+// AbortWithDbError sends back an error that came from the db package,
+// the status and code are picked from the type of the error:
+//
+//	db.ErrNotFound                          404 not_found
+//	db.ErrItemExists                        409 conflict
+//	db.ErrVersionMismatch                   412 precondition_failed
+//	db.ErrInvalidItem                       422 validation_failed
+//	db.ErrInvalidQuery, db.ErrInvalidCursor 400 bad_request
+//	db.ErrInvalidPatch                      400 bad_request
+//	db.ErrInvalidImport, db.ErrUnknownFormat 400 bad_request
+//	db.ErrDegraded                          503 unavailable
+//	anything else                           500 internal_error
+//
+// The message of a 500 does not have the error in it, it could be
+// something like a file name or the address of redis, which clients
+// have no business knowing.  It is in the log, under the request id
+func AbortWithDbError(c *gin.Context, what string, err error) {
+	status, detail := DbErrorDetail(what, err, GetRequestId(c))
+	c.AbortWithStatusJSON(status, ErrorResponse{Error: detail})
+}
+
+// DbErrorDetail logs an error that came from the db package, and
+// returns the status and the description of it to send back, see
+// AbortWithDbError.  The WebSocket API uses it for its commands
+func DbErrorDetail(what string, err error, requestId string) (int, ErrorDetail) {
+	log.Printf("[%s] %s: %v", requestId, what, err)
+
+	var status int
+	var code string
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		status, code = http.StatusNotFound, CodeNotFound
+	case errors.Is(err, db.ErrItemExists):
+		status, code = http.StatusConflict, CodeConflict
+	case errors.Is(err, db.ErrVersionMismatch):
+		status, code = http.StatusPreconditionFailed, CodePreconditionFailed
+	case errors.Is(err, db.ErrInvalidItem):
+		status, code = http.StatusUnprocessableEntity, CodeValidationFailed
+	case errors.Is(err, db.ErrInvalidQuery), errors.Is(err, db.ErrInvalidCursor),
+		errors.Is(err, db.ErrInvalidPatch), errors.Is(err, db.ErrInvalidImport),
+		errors.Is(err, db.ErrUnknownFormat):
+		status, code = http.StatusBadRequest, CodeBadRequest
+	case errors.Is(err, db.ErrDegraded):
+		status, code = http.StatusServiceUnavailable, CodeUnavailable
+	default:
+		return http.StatusInternalServerError, ErrorDetail{
+			Code:      CodeInternalError,
+			Message:   fmt.Sprintf("%s, see the API log for request %s", what, requestId),
+			RequestId: requestId,
+		}
+	}
+	return status, NewErrorDetail(code, err, requestId)
+}
+
+// IdParam reads the id path parameter, it sends back a 400 and returns
+// false if it is not a positive number
+func IdParam(c *gin.Context) (int, bool) {
+	//Note go is minimalistic, so we have to get the
+	//id parameter using the Param() function, and then
+	//convert it to an int using the strconv package
+	idS := c.Param("id")
+	id, err := strconv.Atoi(idS)
+	if err != nil || id < 1 {
+		AbortWithError(c, http.StatusBadRequest, CodeBadRequest, "Error converting id to int",
+			fmt.Errorf("id must be a positive number, got %q", idS))
+		return 0, false
+	}
+	return id, true
+}
+
+// BindItem reads the todo in the body of the request.  It sends back a
+// 400 and returns false if the body is not JSON, or does not look like
+// a todo, and a 422 with the details if it has fields a todo does not
+// have.  The rules on the fields themselves are checked by the db
+// package when the todo is stored
+func BindItem(c *gin.Context) (db.ToDoItem, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		AbortWithError(c, http.StatusBadRequest, CodeBadRequest, "Error reading body", err)
+		return db.ToDoItem{}, false
+	}
+
+	item, err := db.DecodeItem(body)
+	if errors.Is(err, db.ErrInvalidItem) {
+		AbortWithDbError(c, "Error binding JSON", err)
+		return db.ToDoItem{}, false
+	}
+	if err != nil {
+		AbortWithError(c, http.StatusBadRequest, CodeBadRequest, "Error binding JSON", err)
+		return db.ToDoItem{}, false
+	}
+	return item, true
+}
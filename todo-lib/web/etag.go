@@ -0,0 +1,86 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"drexel.edu/todo-lib/db"
+	"github.com/gin-gonic/gin"
+)
+
+// The ETag of a todo is its version in quotes, for example "3".  A
+// client that sends it back in an If-Match header only changes the todo
+// if nobody else changed it since the client read it, and a client that
+// sends it back in an If-None-Match header gets a 304 Not Modified
+// instead of the todo if it did not change.  See RFC 9110 section 13
+
+// ETag returns the ETag of the item
+func ETag(item db.ToDoItem) string {
+	return `"` + strconv.Itoa(item.Version) + `"`
+}
+
+// SetETag sends the ETag of the item back in the ETag header
+func SetETag(c *gin.Context, item db.ToDoItem) {
+	c.Header("ETag", ETag(item))
+}
+
+// IfMatchVersion reads the If-Match header, and returns the version the
+// todo must be at to be changed, or db.AnyVersion if there is no header
+// or it is *.  It sends back an error and returns false if the header
+// can never match, or has more than one of our ETags
+func IfMatchVersion(c *gin.Context) (int, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return db.AnyVersion, true
+	}
+
+	var versions []int
+	for _, tag := range strings.Split(header, ",") {
+		//If-Match uses the strong comparison, so weak ETags, the ones
+		//that start with W/, never match
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+			continue
+		}
+		version, err := strconv.Atoi(tag[1 : len(tag)-1])
+		if err != nil || version < 0 {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	switch len(versions) {
+	case 0:
+		AbortWithDbError(c, "Error checking If-Match", db.ErrVersionMismatch)
+		return 0, false
+	case 1:
+		return versions[0], true
+	default:
+		AbortWithError(c, http.StatusBadRequest, CodeBadRequest, "Error checking If-Match",
+			errors.New("If-Match can only have one ETag"))
+		return 0, false
+	}
+}
+
+// NotModified returns true if the If-None-Match header has the ETag of
+// the item, or is *.  The client already has this version of the todo
+func NotModified(c *gin.Context, item db.ToDoItem) bool {
+	header := strings.TrimSpace(c.GetHeader("If-None-Match"))
+	if header == "" {
+		return false
+	}
+	if header == "*" {
+		return true
+	}
+
+	//If-None-Match uses the weak comparison, W/"3" matches "3"
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == ETag(item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package web
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stats keeps the live numbers that the health endpoints report.
+// Every request goes through CountRequests, which bumps the counters
+// with atomics, so keeping them does not need a lock
+type Stats struct {
+	started      time.Time
+	requests     atomic.Int64
+	clientErrors atomic.Int64
+	serverErrors atomic.Int64
+}
+
+// probePaths are the endpoints that Kubernetes, docker compose, load
+// balancers and prometheus call every few seconds.  They are not
+// counted, or requests_served would mostly be counting probes
+var probePaths = map[string]bool{
+	"/health":  true,
+	"/healthz": true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+// NewStats returns Stats that count from now
+func NewStats() *Stats {
+	return &Stats{started: time.Now()}
+}
+
+// CountRequests returns a gin middleware that counts every request,
+// every request that was answered with a 4xx status, and every request
+// that ended in a server error, that is a 5xx status or a panic
+func (s *Stats) CountRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if probePaths[c.FullPath()] {
+			c.Next()
+			return
+		}
+		s.requests.Add(1)
+
+		//gin.Recovery runs before us, so a panic in a handler passes
+		//through here on its way to being turned into a 500.  We count
+		//it and let it keep going
+		defer func() {
+			if r := recover(); r != nil {
+				s.serverErrors.Add(1)
+				panic(r)
+			}
+		}()
+
+		c.Next()
+
+		switch status := c.Writer.Status(); {
+		case status >= http.StatusInternalServerError:
+			s.serverErrors.Add(1)
+		case status >= http.StatusBadRequest:
+			s.clientErrors.Add(1)
+		}
+	}
+}
+
+// Report returns the numbers in the shape the /health endpoint sends
+// them back, uptime is in seconds
+func (s *Stats) Report() gin.H {
+	return gin.H{
+		"started":            s.started.UTC().Format(time.RFC3339),
+		"uptime":             int64(time.Since(s.started).Seconds()),
+		"requests_served":    s.requests.Load(),
+		"client_errors":      s.clientErrors.Load(),
+		"errors_encountered": s.serverErrors.Load(),
+	}
+}
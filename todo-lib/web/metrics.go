@@ -0,0 +1,70 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// These are the HTTP metrics that every one of our gin services exposes
+// on /metrics.  They are labeled with the route pattern, like /todo/:id,
+// and not with the path that was requested, otherwise every id would
+// become a time series of its own.  promauto registers them with the
+// default prometheus registry, which is what promhttp.Handler serves,
+// along with the go runtime and process metrics
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests served, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "How long it took to serve HTTP requests, by method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// unmatchedRoute is the route label of requests that did not match any
+// of our routes, they all end up in one time series
+const unmatchedRoute = "unmatched"
+
+// RecordMetrics returns a gin middleware that counts and times every
+// request, register it with r.Use before adding the routes
+func RecordMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		//gin.Recovery runs before us and only writes the 500 after a
+		//panic has passed through here, so we record that status
+		//ourselves and let the panic keep going
+		panicked := true
+		defer func() {
+			status := c.Writer.Status()
+			if panicked {
+				status = http.StatusInternalServerError
+			}
+			route := c.FullPath()
+			if route == "" {
+				route = unmatchedRoute
+			}
+
+			httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(status)).Inc()
+			httpRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+		}()
+
+		c.Next()
+		panicked = false
+	}
+}
+
+// MetricsHandler is the implementation of GET /metrics, it answers in
+// the text format that prometheus scrapes
+func MetricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
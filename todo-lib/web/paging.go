@@ -0,0 +1,72 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"drexel.edu/todo-lib/db"
+	"github.com/gin-gonic/gin"
+)
+
+// Page is the body of one page of todos, the todos on the page and the
+// cursor of the next page, which is left out on the last page
+type Page struct {
+	Items      []db.ToDoItem `json:"items"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// WantsPage returns true if the client asked for one page of a list by
+// providing a limit or a cursor.  Without them the list endpoints return
+// everything, just like they always did
+func WantsPage(c *gin.Context) bool {
+	return c.Query("limit") != "" || c.Query("cursor") != ""
+}
+
+// PageParams reads the limit and cursor query parameters.  The limit is
+// db.DefaultPageLimit if it is not provided, and at most db.MaxPageLimit
+func PageParams(c *gin.Context) (string, int, error) {
+	limit := db.DefaultPageLimit
+	if limitS := c.Query("limit"); limitS != "" {
+		l, err := strconv.Atoi(limitS)
+		if err != nil || l < 1 {
+			return "", 0, fmt.Errorf("limit must be a positive number, got %q", limitS)
+		}
+		limit = l
+	}
+	if limit > db.MaxPageLimit {
+		limit = db.MaxPageLimit
+	}
+
+	return c.Query("cursor"), limit, nil
+}
+
+// SetPageHeaders tells the client how to get the next page.  The cursor
+// goes in the X-Next-Cursor header, and the whole URL of the next page
+// goes in a Link header with rel="next" (RFC 8288), which a lot of HTTP
+// clients know how to follow.  Nothing is set on the last page
+func SetPageHeaders(c *gin.Context, nextCursor string, limit int) {
+	if nextCursor == "" {
+		return
+	}
+
+	query := c.Request.URL.Query()
+	query.Set("cursor", nextCursor)
+	query.Set("limit", strconv.Itoa(limit))
+	next := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+
+	c.Header("X-Next-Cursor", nextCursor)
+	c.Header("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+}
+
+// WritePage sends one page of todos.  The cursor of the next page is in
+// the body, for clients that do not look at headers, and in the headers
+// set by SetPageHeaders
+func WritePage(c *gin.Context, items []db.ToDoItem, nextCursor string, limit int) {
+	if items == nil {
+		items = []db.ToDoItem{}
+	}
+	SetPageHeaders(c, nextCursor, limit)
+	c.JSON(http.StatusOK, Page{Items: items, NextCursor: nextCursor})
+}
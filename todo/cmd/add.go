@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+
+	"drexel.edu/todo/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the add command
+var (
+	addID          int
+	addTitle       string
+	addDone        bool
+	addDescription string
+	addPriority    string
+	addTags        []string
+	addDue         string
+)
+
+var addCmd = &cobra.Command{
+	Use:   "add --title <title> [--id <id>]",
+	Short: "Add an item to the database",
+	Long: `Add an item to the database.
+
+If --id is not provided the next free id is assigned to the item.`,
+	Example: `  todo add --title "Learn Docker"
+  todo add --id 6 --title "Learn Go" --done
+  todo add --title "Finish the lab" --due 2024-05-01 --priority high --tag school --tag go`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		due, err := parseDue(addDue)
+		if err != nil {
+			return err
+		}
+
+		item := db.ToDoItem{
+			Id:          addID,
+			Title:       addTitle,
+			IsDone:      addDone,
+			Description: addDescription,
+			Priority:    addPriority,
+			Tags:        addTags,
+			DueDate:     due,
+		}
+		newItem, err := todo.AddItem(item)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Added item", newItem.Id)
+		return nil
+	},
+}
+
+func init() {
+	addCmd.Flags().IntVar(&addID, "id", 0, "Id of the new item (default next free id)")
+	addCmd.Flags().StringVar(&addTitle, "title", "", "Title of the new item")
+	addCmd.Flags().BoolVar(&addDone, "done", false, "Mark the new item as done")
+	addCmd.Flags().StringVar(&addDescription, "description", "", "Longer description of the new item")
+	addCmd.Flags().StringVar(&addPriority, "priority", "", "Priority of the new item: low, medium or high")
+	addCmd.Flags().StringSliceVar(&addTags, "tag", nil, "Tag for the new item, repeat or comma separate for more")
+	addCmd.Flags().StringVar(&addDue, "due", "", "Due date of the new item, YYYY-MM-DD or RFC3339")
+	addCmd.MarkFlagRequired("title")
+
+	rootCmd.AddCommand(addCmd)
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var doneCmd = &cobra.Command{
+	Use:               "done <id>",
+	Short:             "Mark an item as done",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return changeDoneStatus(args[0], true)
+	},
+}
+
+var undoneCmd = &cobra.Command{
+	Use:               "undone <id>",
+	Aliases:           []string{"reopen"},
+	Short:             "Mark an item as not done",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return changeDoneStatus(args[0], false)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doneCmd)
+	rootCmd.AddCommand(undoneCmd)
+}
+
+// changeDoneStatus is shared by the done and undone commands
+func changeDoneStatus(arg string, value bool) error {
+	id, err := parseID(arg)
+	if err != nil {
+		return err
+	}
+
+	todo, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	if err := todo.ChangeItemDoneStatus(id, value); err != nil {
+		return err
+	}
+
+	fmt.Printf("Item %d done status set to %t\n", id, value)
+	return nil
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"drexel.edu/todo/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the edit command
+var (
+	editTitle       string
+	editDone        bool
+	editDescription string
+	editPriority    string
+	editTags        []string
+	editDue         string
+)
+
+var editCmd = &cobra.Command{
+	Use:   "edit <id> [--title <title>] [--done=true|false] [--description <text>] [--priority <priority>] [--tag <tag>] [--due <date>]",
+	Short: "Change the title, done status or details of an item",
+	Example: `  todo edit 3 --title "Learn Cloud Native Architecture in depth"
+  todo edit 3 --done=false
+  todo edit 3 --due 2024-06-01 --tag cloud
+  todo edit 3 --due "" --tag ""`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+
+		//Only the flags that were actually provided are changed, so
+		//we need to know which ones were set on the command line
+		flags := cmd.Flags()
+		if flags.NFlag() == 0 || (flags.NFlag() == 1 && flags.Changed("db")) {
+			return errors.New("nothing to change, provide at least one of --title, --done, --description, --priority, --tag or --due")
+		}
+		due, err := parseDue(editDue)
+		if err != nil {
+			return err
+		}
+
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		//The changes are made while the database is locked, so a todo
+		//done or rm running at the same time is not overwritten
+		item, err := todo.EditItem(id, func(item *db.ToDoItem) error {
+			if flags.Changed("title") {
+				item.Title = editTitle
+			}
+			if flags.Changed("done") {
+				item.IsDone = editDone
+			}
+			if flags.Changed("description") {
+				item.Description = editDescription
+			}
+			if flags.Changed("priority") {
+				item.Priority = editPriority
+			}
+			if flags.Changed("tag") {
+				//--tag "" clears the tags
+				item.Tags = nil
+				for _, tag := range editTags {
+					if tag != "" {
+						item.Tags = append(item.Tags, tag)
+					}
+				}
+			}
+			if flags.Changed("due") {
+				item.DueDate = due
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Updated item", item.Id)
+		return nil
+	},
+}
+
+func init() {
+	editCmd.Flags().StringVar(&editTitle, "title", "", "New title for the item")
+	editCmd.Flags().BoolVar(&editDone, "done", false, "New done status for the item")
+	editCmd.Flags().StringVar(&editDescription, "description", "", "New description for the item")
+	editCmd.Flags().StringVar(&editPriority, "priority", "", "New priority for the item: low, medium or high")
+	editCmd.Flags().StringSliceVar(&editTags, "tag", nil, "Replace the tags of the item, an empty value removes them")
+	editCmd.Flags().StringVar(&editDue, "due", "", "New due date for the item, YYYY-MM-DD or RFC3339, empty removes it")
+
+	rootCmd.AddCommand(editCmd)
+}
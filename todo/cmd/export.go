@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Flags for the export command
+var exportFormat string
+
+var exportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Write all the items in the database to a JSON, CSV or NDJSON file",
+	Long: `Write all the items in the database to a JSON, CSV or NDJSON file.
+
+Without a file, or with -, the items are written to standard output.
+The format is picked from the extension of the file, use --format to
+override it.  The file can be loaded into another database with
+"todo import", or into one of the todo APIs with POST /todo/bulk.`,
+	Example: `  todo export todos.csv
+  todo export --format ndjson > todos.ndjson`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fileName := "-"
+		if len(args) == 1 {
+			fileName = args[0]
+		}
+
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		//Build the whole export first, so a failure does not leave half
+		//of a file behind
+		var export bytes.Buffer
+		if err := todo.ExportItems(&export, formatFor(fileName, exportFormat)); err != nil {
+			return err
+		}
+
+		if fileName == "-" {
+			_, err = cmd.OutOrStdout().Write(export.Bytes())
+			return err
+		}
+		return os.WriteFile(fileName, export.Bytes(), 0644)
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "", "Format of the file: json, csv or ndjson (default from the file extension)")
+
+	rootCmd.AddCommand(exportCmd)
+}
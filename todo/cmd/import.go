@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Flags for the import command
+var importFormat string
+
+var importCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Add the items in a JSON, CSV or NDJSON file to the database",
+	Long: `Add the items in a JSON, CSV or NDJSON file to the database.
+
+The file can be an export of this database, of another one, or of one
+of the todo APIs (GET /todo/export).  Without a file, or with -, the
+items are read from standard input.  The format is picked from the
+extension of the file, use --format to override it.
+
+Items without an id get the next free id.  An item that cannot be
+added, for example because its id is already used, is reported and
+skipped, the others are still added.`,
+	Example: `  todo import todos.csv
+  todo import --format ndjson < todos.txt`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fileName := "-"
+		if len(args) == 1 {
+			fileName = args[0]
+		}
+
+		var in io.Reader = cmd.InOrStdin()
+		if fileName != "-" {
+			f, err := os.Open(fileName)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		result, err := todo.ImportItems(in, formatFor(fileName, importFormat))
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Imported", result.Imported, "items")
+		for _, importErr := range result.Errors {
+			fmt.Fprintf(cmd.ErrOrStderr(), "  row %d: %s\n", importErr.Row, importErr.Message)
+		}
+		if result.Failed > 0 {
+			return fmt.Errorf("%d items could not be imported", result.Failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	importCmd.Flags().StringVar(&importFormat, "format", "", "Format of the file: json, csv or ndjson (default from the file extension)")
+
+	rootCmd.AddCommand(importCmd)
+}
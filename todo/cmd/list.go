@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var listCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List all the items in the database",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		todoList, err := todo.GetAllItems()
+		if err != nil {
+			return err
+		}
+
+		todo.PrintAllItems(todoList)
+		fmt.Println("THERE ARE", len(todoList), "ITEMS IN THE DB")
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var rmCmd = &cobra.Command{
+	Use:               "rm <id>",
+	Aliases:           []string{"delete"},
+	Short:             "Delete an item from the database",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		if err := todo.DeleteItem(id); err != nil {
+			return err
+		}
+
+		fmt.Println("Deleted item", id)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rmCmd)
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/spf13/cobra"
+)
+
+// dbFileName holds the value of the persistent --db flag, it is
+// shared by all of the subcommands
+var dbFileName string
+
+// rootCmd is the base "todo" command, on its own it just prints help.
+// Each subcommand lives in its own file and registers itself with
+// rootCmd in an init() function, which is the usual cobra layout
+var rootCmd = &cobra.Command{
+	Use:   "todo",
+	Short: "Manage a list of todo items kept in a JSON file",
+	Long: `todo manages a list of todo items that are kept in a JSON file.
+
+Use "todo completion <shell>" to generate a shell completion script.`,
+
+	//We print errors ourselves in main, and we only want the usage
+	//text when the user got the command line wrong, not every time
+	//a database operation fails
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&dbFileName, "db", "./data/todo.json", "Name of the database file")
+}
+
+// Execute runs the todo CLI.  It returns the error from the command
+// that ran, so main can report it and exit with a failure code
+func Execute() error {
+	return rootCmd.Execute()
+}
+
+// openDB creates the db object for the database selected with --db
+func openDB() (*db.ToDo, error) {
+	return db.New(dbFileName)
+}
+
+// parseID converts the item id passed on the command line to an int
+func parseID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid item id %q, it must be a number", arg)
+	}
+	return id, nil
+}
+
+// parseDue converts the due date passed on the command line, either
+// just a date like 2024-05-01 or a full RFC3339 time.  An empty string
+// means no due date
+func parseDue(arg string) (*time.Time, error) {
+	if arg == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if due, err := time.Parse(layout, arg); err == nil {
+			return &due, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid due date %q, use YYYY-MM-DD or RFC3339", arg)
+}
+
+// formatFor returns the import or export format to use for the file,
+// the --format flag if it was given, otherwise the one that goes with
+// the extension of the file.  Standard input and output, and files
+// with any other extension, are JSON
+func formatFor(fileName string, flag string) string {
+	if flag != "" {
+		return flag
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		return db.FormatCSV
+	case ".ndjson", ".jsonl":
+		return db.FormatNDJSON
+	default:
+		return db.FormatJSON
+	}
+}
+
+// completeIDs is used by the shell completion scripts to offer the
+// ids of the items in the database, with their titles as hints
+func completeIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	//All of the commands that use this take a single id
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	todo, err := openDB()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	todoList, err := todo.GetAllItems()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var ids []string
+	for _, item := range todoList {
+		ids = append(ids, fmt.Sprintf("%d\t%s", item.Id, item.Title))
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var showCmd = &cobra.Command{
+	Use:               "show <id>",
+	Aliases:           []string{"get"},
+	Short:             "Show a single item from the database",
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		item, err := todo.GetItem(id)
+		if err != nil {
+			return err
+		}
+
+		todo.PrintItem(item)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(showCmd)
+}
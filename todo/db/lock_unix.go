@@ -0,0 +1,49 @@
+//go:build !windows
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileLock is an advisory lock on a file.  On unix systems we use
+// flock(), the lock is released automatically by the OS if the
+// process dies while holding it
+type fileLock struct {
+	f *os.File
+}
+
+// lockFile blocks until it holds an exclusive lock on fileName,
+// creating the file if needed.  The lock file itself is never
+// removed, removing it would let two processes lock different files
+func lockFile(fileName string) (*fileLock, error) {
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) unlock() error {
+	defer l.f.Close()
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
+
+// syncDir flushes a directory to disk, which makes a rename of a
+// file inside of it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
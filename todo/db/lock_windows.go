@@ -0,0 +1,54 @@
+//go:build windows
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// How long we are willing to wait for another todo invocation to
+// release the lock before giving up
+const (
+	lockRetryInterval = 50 * time.Millisecond
+	lockTimeout       = 10 * time.Second
+)
+
+// fileLock is an advisory lock on a file.  Windows does not have
+// flock(), so we take the lock by exclusively creating the lock file
+// and release it by removing the file again
+type fileLock struct {
+	fileName string
+}
+
+// lockFile blocks until it holds an exclusive lock on fileName, or
+// until lockTimeout passes
+func lockFile(fileName string) (*fileLock, error) {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.Close()
+			return &fileLock{fileName: fileName}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s, remove it if no other todo is running", fileName)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (l *fileLock) unlock() error {
+	return os.Remove(l.fileName)
+}
+
+// syncDir is a no-op on windows, directories cannot be opened
+// for syncing and renames are already flushed by the OS
+func syncDir(dir string) error {
+	return nil
+}
@@ -1,23 +1,83 @@
 package db
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"time"
 )
 
-// ToDoItem is the struct that represents a single ToDo item
+// These are the suffixes of the files we keep next to the database
+// file.  The lock file is used to coordinate concurrent invocations
+// of the todo CLI, and the backup file holds the version of the
+// database from before the last successful save.  Note we do not use
+// ".bak" because that is our sample data that "make restore-db" uses
+const (
+	lockFileSuffix   = ".lock"
+	backupFileSuffix = ".prev"
+)
+
+// ToDoItem is the struct that represents a single ToDo item.  Everything
+// after IsDone was added later, so those fields are optional in JSON
+// and older records that do not have them still load.  The timestamps
+// are maintained by the db package, whatever a client sends for them
+// is overwritten.
+//
+// The validate tags are the rules an item must follow to be stored,
+// see ValidateItem.  An id of 0 means the item does not have one yet
 type ToDoItem struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	IsDone bool   `json:"done"`
+	Id          int        `json:"id" validate:"gte=0"`
+	Title       string     `json:"title" validate:"notblank,max=200"`
+	IsDone      bool       `json:"done"`
+	Description string     `json:"description,omitempty" validate:"max=2000"`
+	Priority    string     `json:"priority,omitempty" validate:"omitempty,oneof=low medium high"`
+	Tags        []string   `json:"tags,omitempty" validate:"max=20,dive,notblank,max=50"`
+	DueDate     *time.Time `json:"dueDate,omitempty"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
 }
 
+// These are the priorities a ToDoItem can have, an empty Priority
+// means that no priority was set
+const (
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+)
+
+// These are the errors the ToDo functions return when the item they
+// are asked about is, or is not, in the database, or cannot be stored.
+// Check for them with errors.Is
+
+// ErrNotFound is returned when there is no item with the requested id
+var ErrNotFound = errors.New("item does not exist")
+
+// ErrItemExists is returned when adding an item whose id is already
+// in use
+var ErrItemExists = errors.New("item already exists")
+
+// ErrInvalidItem is returned when an item cannot be stored the way it
+// is, see ValidateItem
+var ErrInvalidItem = errors.New("invalid item")
+
 // DbMap is a type alias for a map of ToDoItems.  The key
 // will be the ToDoItem.Id and the value will be the ToDoItem
 type DbMap map[int]ToDoItem
 
+// dbFile is the layout of our JSON database file.  Besides the items
+// it keeps the next id to hand out to items that are added without
+// one, so ids of deleted items are never reused.  Older database files
+// are a plain json array of items, loadDB still accepts those
+type dbFile struct {
+	NextId int        `json:"nextId"`
+	Items  []ToDoItem `json:"items"`
+}
+
 // ToDo is the struct that represents the main object of our
 // todo app.  It contains a map of ToDoItems and the name of
 // the file that is used to store the items.
@@ -30,6 +90,7 @@ type DbMap map[int]ToDoItem
 // ANSWER: <GOES HERE>
 type ToDo struct {
 	toDoMap    DbMap
+	nextId     int
 	dbFileName string
 }
 
@@ -81,20 +142,44 @@ func New(dbFile string) (*ToDo, error) {
 //	 (1) The item will be added to the DB
 //		(2) The DB file will be saved with the item added
 //		(3) If there is an error, it will be returned
-func (t *ToDo) AddItem(item ToDoItem) error {
-	//TODO: Implement this function
-	//Start by loading the database into the private map in our struct
-	//see the loadDB() helper.  Then make sure the item we want to load
-	//has a unique ID.  Do this by checking if the item already exists
-	//in the map.  If it does, return an error with a proper message
-	//see (errors.New("MESSAGE GOES HERE")).  If the item does not exist
-	//in the map, add it to the map.  Then save the DB using the saveDB()
-	//helper.  If there are any errors, return them, as appropriate.
-	//If everything there are no errors, this function should return nil
-	//at the end to indicate that the item was properly added to the
-	//database.
-
-	return errors.New("AddItem() is currently not implemented")
+//		(4) If the item did not have an id (it is 0), the next free
+//			id is assigned to it.  The item as it was stored,
+//			including its id, is returned
+//		(5) An item that breaks the validation rules on ToDoItem is
+//			not added, a *ValidationError is returned instead
+func (t *ToDo) AddItem(item ToDoItem) (ToDoItem, error) {
+	if err := ValidateItem(item); err != nil {
+		return ToDoItem{}, err
+	}
+
+	err := t.modifyDB(func() error {
+		stampNewItem(&item, time.Now().UTC())
+
+		//The id counter is read and bumped while we hold the database
+		//lock, so two todo invocations will never get the same id
+		if item.Id == 0 {
+			item.Id = t.nextId
+		}
+
+		//Before we add an item to the DB, lets make sure
+		//it does not exist, if it does, return an error
+		if _, ok := t.toDoMap[item.Id]; ok {
+			return ErrItemExists
+		}
+
+		//Now that we know the item doesn't exist, lets add it to our map,
+		//modifyDB will save the database for us
+		t.toDoMap[item.Id] = item
+		if item.Id >= t.nextId {
+			t.nextId = item.Id + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	return item, nil
 }
 
 // DeleteItem accepts an item id and removes it from the DB.
@@ -111,19 +196,15 @@ func (t *ToDo) AddItem(item ToDoItem) error {
 //		(2) The DB file will be saved with the item removed
 //		(3) If there is an error, it will be returned
 func (t *ToDo) DeleteItem(id int) error {
-	//TODO: Implement this function
-	//Like the add item function, start by loading the database into the
-	//private map in our struct.  Then make sure the item we want to delete
-	//exists in the map.  After all we cannot delete an item that is not
-	//in the database. If the item is in our internal map t.toDoMap, then
-	//delete it.  You can use the built-in go delete() function to do this.
-	//We covered this in the go tutorial. As the final step, save the DB
-	//using the saveDB() helper.  If there are any errors, return them, as
-	//appropriate.  If everything there are no errors, this function should
-	//return nil at the end to indicate that the item was properly deleted
-	//from the database.
-
-	return errors.New("DeleteItem() is currently not implemented")
+	return t.modifyDB(func() error {
+		//We cannot delete an item that is not in the database
+		if _, ok := t.toDoMap[id]; !ok {
+			return ErrNotFound
+		}
+
+		delete(t.toDoMap, id)
+		return nil
+	})
 }
 
 // UpdateItem accepts a ToDoItem and updates it in the DB.
@@ -139,20 +220,77 @@ func (t *ToDo) DeleteItem(id int) error {
 //	 (1) The item will be updated in the DB
 //		(2) The DB file will be saved with the item updated
 //		(3) If there is an error, it will be returned
+//		(4) An item that breaks the validation rules on ToDoItem is
+//			not updated, a *ValidationError is returned instead
 func (t *ToDo) UpdateItem(item ToDoItem) error {
-	//TODO: Implement this function
-	//Like the add and delete functions, start by loading the database
-	//into the private map in our struct.  Then make sure the item we
-	//want to update exists in the map.  After all we cannot update an
-	//item that is not in the database. If the item is in our internal
-	//map t.toDoMap, then update it.  You can do this by simply assigning
-	//the item to the map.  We covered this in the go tutorial. As the
-	//final step, save the DB using the saveDB() helper.  If there are
-	//any errors, return them, as appropriate.  If everything there are
-	//no errors, this function should return nil at the end to indicate
-	//that the item was properly updated in the database.
-
-	return errors.New("UpdateItem() is currently not implemented")
+	if err := ValidateItem(item); err != nil {
+		return err
+	}
+
+	return t.modifyDB(func() error {
+		//We cannot update an item that is not in the database
+		existing, ok := t.toDoMap[item.Id]
+		if !ok {
+			return ErrNotFound
+		}
+
+		//Now that we know the item exists, lets replace it, keeping
+		//the timestamps up to date
+		stampUpdatedItem(&item, existing, time.Now().UTC())
+		t.toDoMap[item.Id] = item
+		return nil
+	})
+}
+
+// EditItem accepts an item id and a function that changes the item,
+// and saves the changed item in the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB, if not, an
+//	    				error is returned and edit is not called
+//					(3) edit must not change the id of the item
+//
+// Postconditions:
+//
+//	 (1) The item will be changed by edit and saved in the DB
+//		(2) The item is read, changed and written back while the
+//			database lock is held, so another todo invocation cannot
+//			change or delete the item in between.  Use it instead of
+//			GetItem followed by UpdateItem
+//		(3) If edit returns an error nothing is changed, and the
+//			error is returned
+//		(4) An item that breaks the validation rules on ToDoItem
+//			after the edit is not saved, a *ValidationError is
+//			returned instead
+//		(5) The changed item is returned
+func (t *ToDo) EditItem(id int, edit func(item *ToDoItem) error) (ToDoItem, error) {
+	var edited ToDoItem
+	err := t.modifyDB(func() error {
+		existing, ok := t.toDoMap[id]
+		if !ok {
+			return ErrNotFound
+		}
+
+		item := existing
+		if err := edit(&item); err != nil {
+			return err
+		}
+		if item.Id != id {
+			return fmt.Errorf("%w: the id of an item cannot be changed", ErrInvalidItem)
+		}
+		if err := ValidateItem(item); err != nil {
+			return err
+		}
+
+		stampUpdatedItem(&item, existing, time.Now().UTC())
+		t.toDoMap[id] = item
+		edited = item
+		return nil
+	})
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	return edited, nil
 }
 
 // GetItem accepts an item id and returns the item from the DB.
@@ -170,19 +308,16 @@ func (t *ToDo) UpdateItem(item ToDoItem) error {
 //			along with an empty ToDoItem
 //		(3) The database file will not be modified
 func (t *ToDo) GetItem(id int) (ToDoItem, error) {
-	//TODO: Implement this function
-	//Like the add, delete, and update functions, start by loading the
-	//database into the private map in our struct.  Then make sure the
-	//item we want to get exists in the map.  After all we cannot get
-	//an item that is not in the database. If the item is in our internal
-	//map t.toDoMap, then return it.  You can do this by simply returning
-	//the item from the map.  We covered this in the go tutorial.  If there
-	//are any errors, return them, as appropriate.  If everything there are
-	//no errors, this function should return the item requested and nil
-	//as the error value the end to indicate that the item was
-	//properly returned from the database.
-
-	return ToDoItem{}, errors.New("GetItem() is currently not implemented")
+	if err := t.loadDB(); err != nil {
+		return ToDoItem{}, err
+	}
+
+	item, ok := t.toDoMap[id]
+	if !ok {
+		return ToDoItem{}, ErrNotFound
+	}
+
+	return item, nil
 }
 
 // GetAllItems returns all items from the DB.  If successful it
@@ -196,18 +331,91 @@ func (t *ToDo) GetItem(id int) (ToDoItem, error) {
 //			along with an empty slice
 //		(3) The database file will not be modified
 func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
-	//TODO: Implement this function
-	//Like many of the other functions start by loading the database into
-	//the private map in our struct.  Dont forget to return nil and an
-	//appropriate error if the database cannot be loaded. Next create an
-	//empty slice of ToDoItems.  Remember from the tutorial you can do this
-	//by "var toDoList []ToDoItem".  Now that we have an empty slice,
-	//iterate over our map and add each item to our slice.  Remember you
-	//use the built in append() function in go to add an item in a slice.
-	//Finally, if there were no errors along the way, return the slice
-	//and nil as the error value.
-
-	return nil, errors.New("GetAllItems() is currently not implemented")
+	if err := t.loadDB(); err != nil {
+		return nil, err
+	}
+
+	//Now that we have the DB loaded, lets create a slice and
+	//add each item from our map to it
+	var toDoList []ToDoItem
+	for _, item := range t.toDoMap {
+		toDoList = append(toDoList, item)
+	}
+
+	return toDoList, nil
+}
+
+// ImportItems reads todos in the format provided, one of the Format
+// constants, and adds every one of them to the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) Every todo that can be read and added is added.  A todo
+//			that cannot is skipped, and the reason is in the errors
+//			of the returned ImportResult, along with where it is in
+//			the import
+//		(2) Todos are checked and given an id just like AddItem, but
+//			the timestamps they have are kept, so todos exported
+//			from one of the APIs keep them
+//		(3) All of the todos are added while the database lock is
+//			held, and the DB file is saved once
+//		(4) If the data cannot be read at all, or the DB cannot be
+//			saved, nothing is added and the error is returned
+func (t *ToDo) ImportItems(r io.Reader, format string) (ImportResult, error) {
+	rows, err := readImport(r, format)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	var result ImportResult
+	err = t.modifyDB(func() error {
+		result = newImportResult()
+		now := time.Now().UTC()
+		for _, row := range rows {
+			if row.err != nil {
+				result.failed(row, row.err)
+				continue
+			}
+			if err := ValidateItem(row.item); err != nil {
+				result.failed(row, err)
+				continue
+			}
+
+			item := row.item
+			stampImportedItem(&item, now)
+			if item.Id == 0 {
+				item.Id = t.nextId
+			}
+			if _, ok := t.toDoMap[item.Id]; ok {
+				result.failed(row, ErrItemExists)
+				continue
+			}
+
+			t.toDoMap[item.Id] = item
+			if item.Id >= t.nextId {
+				t.nextId = item.Id + 1
+			}
+			result.succeeded(item)
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	return result, nil
+}
+
+// ExportItems writes every item in the DB to w in the format provided,
+// one of the Format constants, in id order.  The file can be imported
+// again with ImportItems, or into any of the todo APIs
+func (t *ToDo) ExportItems(w io.Writer, format string) error {
+	items, err := t.GetAllItems()
+	if err != nil {
+		return err
+	}
+	return writeExport(w, format, items)
 }
 
 // PrintItem accepts a ToDoItem and prints it to the console
@@ -231,13 +439,18 @@ func (t *ToDo) PrintAllItems(itemList []ToDoItem) {
 // This is helpful because the CLI accepts todo items for insertion
 // and updates in JSON format.  We need to convert it to a ToDoItem
 // struct to perform any operations on it.
+//
+// Unknown fields and items that break the validation rules are
+// rejected, the error is a *ValidationError with the details.
 func (t *ToDo) JsonToItem(jsonString string) (ToDoItem, error) {
-	var item ToDoItem
-	err := json.Unmarshal([]byte(jsonString), &item)
+	item, err := DecodeItem([]byte(jsonString))
 	if err != nil {
 		return ToDoItem{}, err
 	}
 
+	if err := ValidateItem(item); err != nil {
+		return ToDoItem{}, err
+	}
 	return item, nil
 }
 
@@ -257,20 +470,22 @@ func (t *ToDo) JsonToItem(jsonString string) (ToDoItem, error) {
 //
 //	 (1) The items status in the database will be updated
 //		(2) If there is an error, it will be returned.
-//		(3) This function MUST use existing functionality for most of its
-//			work.  For example, it should call GetItem() to get the item
-//			from the DB, then it should call UpdateItem() to update the
-//			item in the DB (after the status is changed).
+//		(3) The item is read and written back while the database lock
+//			is held, so another todo invocation cannot change the
+//			item in between.
 func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
-	//TODO: Implement this function for EXTRA CREDIT if you want
-	//This function builds on all of the other functions you have
-	//implemented.  It should call GetItem() to get the item from
-	//the DB, then it should call UpdateItem() to update the item
-	//in the DB (after the status is changed).  If there are any
-	//errors along the way, return them.  If everything is successful
-	//return nil at the end to indicate that the item was properly
-
-	return errors.New("ChangeItemDoneStatus() is currently not implemented")
+	return t.modifyDB(func() error {
+		item, ok := t.toDoMap[id]
+		if !ok {
+			return ErrNotFound
+		}
+
+		existing := item
+		item.IsDone = value
+		stampUpdatedItem(&item, existing, time.Now().UTC())
+		t.toDoMap[id] = item
+		return nil
+	})
 }
 
 //------------------------------------------------------------
@@ -278,28 +493,37 @@ func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
 //------------------------------------------------------------
 
 // initDB is a helper function that creates a new file with an
-// empty json array.  This is used to make sure that the DB
+// empty list of items.  This is used to make sure that the DB
 // file exists for operations on our ToDo struct.  This function
 // should be called by the New() function if the DB file doesn't
 // exist.  Notice this function does not have a receiver as its
 // used by New() to create the DB file
 func initDB(dbFileName string) error {
-	f, err := os.Create(dbFileName)
+	//We use O_EXCL so that if another todo invocation created the
+	//file after we checked for it, we do not truncate its data
+	f, err := os.OpenFile(dbFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	// Given we are working with a json array as our DB structure
-	// we should initialize the file with an empty array, which
-	// in json is represented as "[]
-	_, err = f.Write([]byte("[]"))
+	// Our DB structure is a json object with the id counter and an
+	// array of items, so we initialize the file with no items and
+	// a counter that starts at 1
+	data, err := json.MarshalIndent(dbFile{NextId: 1, Items: []ToDoItem{}}, "", "  ")
 	if err != nil {
+		f.Close()
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
 		return err
 	}
 
-	f.Close()
-
-	return nil
+	return f.Close()
 }
 
 func (t *ToDo) saveDB() error {
@@ -308,25 +532,122 @@ func (t *ToDo) saveDB() error {
 	//3. Write the json to our file
 
 	//1. Convert our map into a slice
-	var toDoList []ToDoItem
+	toDoList := make([]ToDoItem, 0, len(t.toDoMap))
 	for _, item := range t.toDoMap {
 		toDoList = append(toDoList, item)
 	}
 
-	//2. Marshal the slice into json, lets pretty print it, but
-	//   this is not required
-	data, err := json.MarshalIndent(toDoList, "", "  ")
+	//2. Marshal the slice and the id counter into json, lets pretty
+	//   print it, but this is not required
+	data, err := json.MarshalIndent(dbFile{NextId: t.nextId, Items: toDoList}, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	//3. Write the json to our file
-	err = os.WriteFile(t.dbFileName, data, 0644)
+	//3. Write the json to our file.  We never write over the database
+	//   in place, a crash half way through would leave us with a
+	//   truncated file.  Instead we write a temp file next to it, flush
+	//   it to disk, keep a copy of the current version as a backup and
+	//   then rename the temp file over the database.  A rename within
+	//   a directory is atomic, so readers either see the old or the new
+	//   version of the file, never a partial one
+	return writeFileAtomic(t.dbFileName, data)
+}
+
+// modifyDB runs a load/modify/save cycle on the database while
+// holding an exclusive lock, so two todo invocations can't clobber
+// each other's changes.  The modify function operates on t.toDoMap
+// and if it returns an error nothing is saved.
+func (t *ToDo) modifyDB(modify func() error) error {
+	lock, err := lockFile(t.dbFileName + lockFileSuffix)
 	if err != nil {
 		return err
 	}
+	defer lock.unlock()
 
-	return nil
+	if err := t.loadDB(); err != nil {
+		return err
+	}
+
+	if err := modify(); err != nil {
+		return err
+	}
+
+	return t.saveDB()
+}
+
+// writeFileAtomic replaces fileName with data using the
+// write-to-temp-then-rename approach, keeping the previous
+// contents of fileName in a backup file next to it
+func writeFileAtomic(fileName string, data []byte) error {
+	dir, base := filepath.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, base+".tmp*")
+	if err != nil {
+		return err
+	}
+	//If anything goes wrong below, dont leave the temp file behind.
+	//Once the rename succeeds there is nothing to remove
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	//Sync makes sure the data is on disk before we rename the file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := backupFile(fileName, fileName+backupFileSuffix); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), fileName); err != nil {
+		return err
+	}
+
+	//Finally sync the directory so the rename itself is durable
+	return syncDir(dir)
+}
+
+// backupFile copies the current contents of fileName into backupName.
+// It is not an error if fileName does not exist yet
+func backupFile(fileName string, backupName string) error {
+	src, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(backupName)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
 }
 
 func (t *ToDo) loadDB() error {
@@ -335,17 +656,81 @@ func (t *ToDo) loadDB() error {
 		return err
 	}
 
-	//Now let's unmarshal the data into our map
-	var toDoList []ToDoItem
-	err = json.Unmarshal(data, &toDoList)
+	//Now let's unmarshal the data, older files are just a json array
+	//of items without the id counter
+	var contents dbFile
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &contents.Items)
+	} else {
+		err = json.Unmarshal(data, &contents)
+	}
 	if err != nil {
 		return err
 	}
 
-	//Now let's iterate over our slice and add each item to our map
-	for _, item := range toDoList {
+	//Now let's iterate over our slice and add each item to our map,
+	//starting from an empty map so we always reflect the file.  We
+	//also make sure the id counter is past every id we already have
+	t.nextId = contents.NextId
+	if t.nextId < 1 {
+		t.nextId = 1
+	}
+	t.toDoMap = make(map[int]ToDoItem, len(contents.Items))
+	for _, item := range contents.Items {
 		t.toDoMap[item.Id] = item
+		if item.Id >= t.nextId {
+			t.nextId = item.Id + 1
+		}
 	}
 
 	return nil
 }
+
+//------------------------------------------------------------
+// ITEM HELPERS
+//------------------------------------------------------------
+
+// stampNewItem sets the timestamps of an item that is about to be
+// added to the DB
+func stampNewItem(item *ToDoItem, now time.Time) {
+	item.CreatedAt = &now
+	item.UpdatedAt = &now
+	item.CompletedAt = nil
+	if item.IsDone {
+		item.CompletedAt = &now
+	}
+}
+
+// stampImportedItem sets the timestamps an imported item does not have,
+// the ones it has are kept.  A todo that is not done was never
+// completed
+func stampImportedItem(item *ToDoItem, now time.Time) {
+	if item.CreatedAt == nil {
+		item.CreatedAt = &now
+	}
+	if item.UpdatedAt == nil {
+		item.UpdatedAt = item.CreatedAt
+	}
+	switch {
+	case !item.IsDone:
+		item.CompletedAt = nil
+	case item.CompletedAt == nil:
+		item.CompletedAt = &now
+	}
+}
+
+// stampUpdatedItem sets the timestamps of an item that is about to
+// replace existing in the DB.  CreatedAt always comes from the existing
+// item, and CompletedAt only changes when the done status changes
+func stampUpdatedItem(item *ToDoItem, existing ToDoItem, now time.Time) {
+	item.CreatedAt = existing.CreatedAt
+	item.UpdatedAt = &now
+	switch {
+	case !item.IsDone:
+		item.CompletedAt = nil
+	case existing.IsDone && existing.CompletedAt != nil:
+		item.CompletedAt = existing.CompletedAt
+	default:
+		item.CompletedAt = &now
+	}
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/go-playground/validator/v10/non-standard/validators"
+)
+
+// These are the limits the validation rules on ToDoItem enforce.  Go
+// does not let us use constants in struct tags, so if you change one
+// of these change the tag on ToDoItem too
+const (
+	MaxTitleLength       = 200
+	MaxDescriptionLength = 2000
+	MaxTags              = 20
+	MaxTagLength         = 50
+)
+
+// FieldError describes one field of an item that broke a validation
+// rule.  Field is the JSON name of the field, like title or tags[2]
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when an item breaks one or more of the
+// validation rules, it has one FieldError for each problem.  It wraps
+// ErrInvalidItem, so errors.Is(err, ErrInvalidItem) is true for it,
+// and errors.As gets to the fields
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Message)
+	}
+	return fmt.Sprintf("%s: %s", ErrInvalidItem, strings.Join(messages, ", "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidItem
+}
+
+// validate checks the `validate` tags on ToDoItem, see
+// github.com/go-playground/validator for the rules.  It reports fields
+// by their JSON names, which is what clients know them by
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	//notblank is like required, but a title of just spaces fails too
+	if err := v.RegisterValidation("notblank", validators.NotBlank); err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// ValidateItem checks the item against the validation rules on
+// ToDoItem, it returns a *ValidationError listing every field that
+// breaks a rule, or nil if the item is fine
+func ValidateItem(item ToDoItem) error {
+	err := validate.Struct(item)
+	if err == nil {
+		return nil
+	}
+
+	var fieldErrors validator.ValidationErrors
+	if !errors.As(err, &fieldErrors) {
+		return err
+	}
+
+	verr := &ValidationError{}
+	for _, fe := range fieldErrors {
+		verr.Fields = append(verr.Fields, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: fieldMessage(fe),
+		})
+	}
+	return verr
+}
+
+// DecodeItem turns JSON into a ToDoItem.  It is strict, a field that
+// ToDoItem does not have is reported as a *ValidationError, so a typo
+// like "titel" is not silently dropped.  JSON that is not valid, or
+// has a value of the wrong type, returns the error from encoding/json.
+// The item itself is not validated, see ValidateItem
+func DecodeItem(data []byte) (ToDoItem, error) {
+	var item ToDoItem
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&item); err != nil {
+		//encoding/json has no error type for unknown fields, only
+		//this message
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			field = strings.Trim(field, `"`)
+			return ToDoItem{}, &ValidationError{Fields: []FieldError{{
+				Field:   field,
+				Rule:    "unknown",
+				Message: fmt.Sprintf("%s is not a field of a todo", field),
+			}}}
+		}
+		return ToDoItem{}, err
+	}
+
+	//Anything after the item, like a second object, is a mistake too
+	if decoder.More() {
+		return ToDoItem{}, errors.New("expected a single todo item")
+	}
+	return item, nil
+}
+
+// fieldMessage turns a broken rule into a message for people
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "notblank":
+		return fmt.Sprintf("%s is required", fe.Field())
+	case "max":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("%s can have at most %s entries", fe.Field(), fe.Param())
+		}
+		return fmt.Sprintf("%s can be at most %s characters long", fe.Field(), fe.Param())
+	case "gte":
+		return fmt.Sprintf("%s must be %s or more", fe.Field(), fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of %s", fe.Field(), strings.ReplaceAll(fe.Param(), " ", ", "))
+	default:
+		return fmt.Sprintf("%s breaks the %s rule", fe.Field(), fe.Tag())
+	}
+}